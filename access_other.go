@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+// writable returns an error wrapping os.ErrPermission if the current user can't create files in the directory dir. Permissions can't be checked ahead of time on this system, so it always returns nil.
+func writable(dir string) error {
+	return nil
+}
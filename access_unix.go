@@ -0,0 +1,10 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+// writable returns an error wrapping os.ErrPermission if the current user can't create files in the directory dir.
+func writable(dir string) error {
+	return syscall.Access(dir, 0x2)
+}
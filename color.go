@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// ANSI escape codes used to color the output on a terminal.
+const (
+	colorReset  = "\x1b[0m"
+	colorBold   = "\x1b[1m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+)
+
+// headerColors are the colors of the messages under each header. Headers not listed are printed plain.
+var headerColors = map[string]string{
+	"Successes":             colorGreen,
+	"Copied":                colorGreen,
+	"Hard linked":           colorGreen,
+	"Merged":                colorGreen,
+	"New":                   colorGreen,
+	"Replace":               colorYellow,
+	"Conflict":              colorRed,
+	"Removed":               colorGreen,
+	"Pruned":                colorGreen,
+	"Directories":           colorGreen,
+	"Linked":                colorGreen,
+	"Skipped":               colorYellow,
+	"Warnings":              colorYellow,
+	"Wrong link":            colorYellow,
+	"Broken link":           colorRed,
+	"Failures":              colorRed,
+	"Errors":                colorRed,
+	"Conflicts":             colorRed,
+	"Directory failures":    colorRed,
+	"Validation errors":     colorRed,
+	"Missing":               colorRed,
+	"Hook failures":         colorRed,
+	"Verification failures": colorRed,
+	"Blocked":               colorRed,
+	"Unknown":               colorRed,
+}
+
+// isTerminal reports whether f is a terminal rather than a pipe or file.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// colorize wraps each line of s in the color. s is returned unchanged if color is empty.
+func colorize(color, s string) string {
+	if color == "" {
+		return s
+	}
+	lines := strings.Split(s, "\n")
+	for n, line := range lines {
+		lines[n] = color + line + colorReset
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// ConfigFiles are the names of the files holding default options. The first one found in the working directory, or else the home directory, is used.
+var ConfigFiles = []string{"bootstrap.yaml", ".bootstraprc"}
+
+// LoadConfig returns the Input built from the defaults, the first config file found, the DotEnv environment variable and the command line args, each overriding the previous. The flags are defined on fs to parse args. A list option given on the command line replaces the values of the config file rather than adding to them. The config file is looked for in the home directory given by -home, if any.
+func LoadConfig(fs *flag.FlagSet, args []string) (Input, error) {
+	i := Input{
+		Dir:           "",
+		Dry:           false,
+		Force:         false,
+		ForceDir:      false,
+		Backup:        false,
+		Status:        false,
+		MkdirAll:      false,
+		LinkFile:      nil,
+		Unlink:        false,
+		JSON:          false,
+		Relative:      false,
+		Verbose:       0,
+		Quiet:         false,
+		CheckSource:   false,
+		Copy:          false,
+		Prune:         false,
+		StateFile:     "",
+		All:           false,
+		NoColor:       false,
+		Only:          nil,
+		Exclude:       nil,
+		Profile:       nil,
+		Timeout:       DefaultTimeout,
+		Root:          "",
+		Interactive:   false,
+		Since:         false,
+		List:          false,
+		AllowedEnv:    nil,
+		DirLinks:      true,
+		Doctor:        false,
+		HomeDir:       "",
+		Retries:       0,
+		RetryDelay:    DefaultRetryDelay,
+		Mirror:        false,
+		Hardlink:      false,
+		AllowMissing:  false,
+		Progress:      false,
+		Trailer:       DefaultTrailer,
+		SymlinksOnly:  false,
+		LinkFileGlob:  "",
+		EnvFile:       nil,
+		BackupDir:     "",
+		DirsFrom:      "",
+		Follow:        false,
+		ParallelDirs:  0,
+		ParallelLinks: 1,
+		Verify:        false,
+		MaxDepth:      -1,
+		Sync:          false,
+		PrintConfig:   false,
+		MergeDirs:     false,
+	}
+	defineFlags(fs, &i)
+
+	// Find the -home flag ahead of the others, leaving fs to report any errors.
+	home := i
+	pre := flag.NewFlagSet("bootstrap", flag.ContinueOnError)
+	pre.SetOutput(io.Discard)
+	defineFlags(pre, &home)
+	if pre.Parse(args) != nil {
+		home = i
+	}
+
+	// Apply the config file values through the flags they correspond to.
+	path, err := findConfig(home.Home())
+	if err != nil {
+		return i, err
+	}
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return i, err
+		}
+		values, err := decodeYAML(f)
+		f.Close()
+		if err != nil {
+			return i, fmt.Errorf("parsing %v: %v", path, err)
+		}
+		// A FlagSet of its own leaves fs to record only the flags set on the command line.
+		file := flag.NewFlagSet(path, flag.ContinueOnError)
+		defineFlags(file, &i)
+		for name, value := range values {
+			if file.Lookup(name) == nil {
+				return i, fmt.Errorf("parsing %v: unknown option %v", path, name)
+			}
+			err = file.Set(name, value)
+			if err != nil {
+				return i, fmt.Errorf("parsing %v: %v: %v", path, name, err)
+			}
+		}
+	}
+
+	// The environment variable holds a list of directories, the flag a comma separated one.
+	if dir := os.Getenv(DotEnv); dir != "" {
+		i.Dir = strings.Join(filepath.SplitList(dir), ",")
+	}
+
+	// Start the list flags afresh, restoring the values of the config file for those not on the command line.
+	lists := map[*[]string][]string{}
+	fs.VisitAll(func(f *flag.Flag) {
+		// Flags such as -l and -linkfile share their values.
+		if g, ok := f.Value.(globs); ok && *g.patterns != nil {
+			lists[g.patterns] = *g.patterns
+			*g.patterns = nil
+		}
+	})
+	err = fs.Parse(args)
+	fs.Visit(func(f *flag.Flag) {
+		if g, ok := f.Value.(globs); ok {
+			delete(lists, g.patterns)
+		}
+	})
+	for patterns, values := range lists {
+		*patterns = values
+	}
+	return i, err
+}
+
+// printConfig writes the options of i to w, as a JSON object if i.JSON is set and as "Name: value" lines otherwise. The source directories are made absolute, as they are searched.
+func printConfig(i Input, w io.Writer) error {
+	if i.Dir != "-" {
+		var dirs []string
+		for _, root := range strings.Split(i.Dir, ",") {
+			dir, err := filepath.Abs(root)
+			if err != nil {
+				return err
+			}
+			dirs = append(dirs, dir)
+		}
+		i.Dir = strings.Join(dirs, ",")
+	}
+	if i.JSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(i)
+	}
+	v := reflect.ValueOf(i)
+	for n := 0; n < v.NumField(); n++ {
+		_, err := fmt.Fprintf(w, "%v: %v\n", v.Type().Field(n).Name, v.Field(n))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findConfig returns the path of the first config file in the working directory or the home directory, or an empty string if there are none.
+func findConfig(home string) (string, error) {
+	for _, dir := range []string{".", home} {
+		for _, name := range ConfigFiles {
+			path := filepath.Join(dir, name)
+			_, err := os.Stat(path)
+			if err == nil {
+				return path, nil
+			}
+			if !os.IsNotExist(err) {
+				return "", err
+			}
+		}
+	}
+	return "", nil
+}
+
+// defineFlags defines the command line flags setting the fields of i.
+func defineFlags(fs *flag.FlagSet, i *Input) {
+	fs.StringVar(&i.Dir, "dir", i.Dir, "")
+	fs.StringVar(&i.Dir, "d", i.Dir, "")
+
+	fs.BoolVar(&i.Dry, "dry", i.Dry, "")
+	fs.BoolVar(&i.Dry, "n", i.Dry, "")
+
+	fs.BoolVar(&i.Force, "force", i.Force, "")
+	fs.BoolVar(&i.Force, "f", i.Force, "")
+
+	fs.BoolVar(&i.ForceDir, "force-dir", i.ForceDir, "")
+
+	fs.BoolVar(&i.Backup, "backup", i.Backup, "")
+	fs.BoolVar(&i.Backup, "b", i.Backup, "")
+
+	fs.BoolVar(&i.Status, "status", i.Status, "")
+	fs.BoolVar(&i.Status, "s", i.Status, "")
+
+	fs.BoolVar(&i.MkdirAll, "mkdir", i.MkdirAll, "")
+	fs.BoolVar(&i.MkdirAll, "p", i.MkdirAll, "")
+
+	fs.Var(globs{&i.LinkFile}, "linkfile", "")
+	fs.Var(globs{&i.LinkFile}, "l", "")
+
+	fs.BoolVar(&i.Unlink, "unlink", i.Unlink, "")
+	fs.BoolVar(&i.Unlink, "u", i.Unlink, "")
+
+	fs.BoolVar(&i.Relative, "relative", i.Relative, "")
+	fs.BoolVar(&i.Relative, "r", i.Relative, "")
+
+	fs.BoolVar(&i.JSON, "json", i.JSON, "")
+
+	fs.Var(verbosity{&i.Verbose}, "v", "")
+
+	fs.BoolVar(&i.Quiet, "quiet", i.Quiet, "")
+	fs.BoolVar(&i.Quiet, "q", i.Quiet, "")
+
+	fs.BoolVar(&i.CheckSource, "check-source", i.CheckSource, "")
+
+	fs.BoolVar(&i.Copy, "copy", i.Copy, "")
+	fs.BoolVar(&i.Copy, "c", i.Copy, "")
+
+	fs.BoolVar(&i.Prune, "prune", i.Prune, "")
+
+	fs.StringVar(&i.StateFile, "state", i.StateFile, "")
+	fs.StringVar(&i.StateFile, "manifest", i.StateFile, "")
+
+	fs.BoolVar(&i.All, "all", i.All, "")
+	fs.BoolVar(&i.All, "a", i.All, "")
+
+	fs.BoolVar(&i.NoColor, "no-color", i.NoColor, "")
+
+	fs.Var(globs{&i.Only}, "only", "")
+
+	fs.Var(globs{&i.Exclude}, "exclude", "")
+
+	fs.Var(globs{&i.Profile}, "profile", "")
+
+	fs.DurationVar(&i.Timeout, "timeout", i.Timeout, "")
+
+	fs.StringVar(&i.Root, "root", i.Root, "")
+
+	fs.BoolVar(&i.Interactive, "i", i.Interactive, "")
+
+	fs.BoolVar(&i.Since, "since", i.Since, "")
+
+	fs.Var(globs{&i.AllowedEnv}, "allow-env", "")
+
+	fs.BoolVar(&i.DirLinks, "dir-links", i.DirLinks, "")
+
+	fs.BoolVar(&i.Doctor, "doctor", i.Doctor, "")
+
+	fs.StringVar(&i.HomeDir, "home", i.HomeDir, "")
+
+	fs.IntVar(&i.Retries, "retries", i.Retries, "")
+	fs.DurationVar(&i.RetryDelay, "retry-delay", i.RetryDelay, "")
+
+	fs.BoolVar(&i.Mirror, "mirror", i.Mirror, "")
+
+	fs.BoolVar(&i.Hardlink, "hardlink", i.Hardlink, "")
+
+	fs.BoolVar(&i.AllowMissing, "allow-missing", i.AllowMissing, "")
+
+	fs.BoolVar(&i.Progress, "progress", i.Progress, "")
+
+	fs.StringVar(&i.Trailer, "trailer", i.Trailer, "")
+
+	fs.BoolVar(&i.SymlinksOnly, "overwrite-symlinks-only", i.SymlinksOnly, "")
+
+	fs.StringVar(&i.LinkFileGlob, "link-file-glob", i.LinkFileGlob, "")
+
+	fs.Var(globs{&i.EnvFile}, "env-file", "")
+
+	fs.StringVar(&i.BackupDir, "backup-dir", i.BackupDir, "")
+
+	fs.StringVar(&i.DirsFrom, "dirs-from", i.DirsFrom, "")
+
+	fs.BoolVar(&i.Follow, "follow", i.Follow, "")
+	fs.IntVar(&i.MaxDepth, "max-depth", i.MaxDepth, "")
+
+	fs.IntVar(&i.ParallelDirs, "parallel-dirs", i.ParallelDirs, "")
+	fs.IntVar(&i.ParallelLinks, "parallel-links", i.ParallelLinks, "")
+
+	fs.BoolVar(&i.Sync, "sync", i.Sync, "")
+	fs.BoolVar(&i.MergeDirs, "merge-dirs", i.MergeDirs, "")
+
+	fs.BoolVar(&i.PrintConfig, "print-config", i.PrintConfig, "")
+
+	fs.BoolVar(&i.Verify, "verify", i.Verify, "")
+
+	fs.BoolVar(&i.List, "list", i.List, "")
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loadConfig runs LoadConfig with the args in a new working directory holding the config file, if not empty, and an empty home directory.
+func loadConfig(t *testing.T, config string, args ...string) Input {
+	t.Helper()
+	wd, home := t.TempDir(), t.TempDir()
+	t.Chdir(wd)
+	t.Setenv("HOME", home)
+	if config != "" {
+		writeFile(t, filepath.Join(wd, ConfigFiles[0]), config)
+	}
+	fs := flag.NewFlagSet("bootstrap", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	i, err := LoadConfig(fs, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return i
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	t.Setenv(DotEnv, "")
+	i := loadConfig(t, "")
+	if i.Dir != "" || i.Force || i.Timeout != DefaultTimeout || !i.DirLinks || i.MaxDepth != -1 || i.ParallelLinks != 1 || i.Trailer != DefaultTrailer {
+		t.Errorf("the defaults are %+v", i)
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	t.Setenv(DotEnv, "")
+	i := loadConfig(t, "dir: /file\nforce: true\ntimeout: 5s\nprofile: laptop\nlinkfile: dotlinks.json\n")
+	if i.Dir != "/file" || !i.Force || i.Timeout != 5*time.Second || !reflect.DeepEqual(i.Profile, []string{"laptop"}) || !reflect.DeepEqual(i.LinkFile, []string{"dotlinks.json"}) {
+		t.Errorf("the file set %+v", i)
+	}
+}
+
+func TestLoadConfigEnv(t *testing.T) {
+	t.Setenv(DotEnv, "/env"+string(filepath.ListSeparator)+"/work")
+	i := loadConfig(t, "dir: /file\n")
+	if want := "/env,/work"; i.Dir != want {
+		t.Errorf("Dir = %q, want %q from $%v", i.Dir, want, DotEnv)
+	}
+}
+
+func TestLoadConfigFlags(t *testing.T) {
+	t.Setenv(DotEnv, "/env")
+	i := loadConfig(t, "force: true\ntimeout: 5s\nprofile: laptop\nonly: vim\nlinkfile: links.yaml\n", "-dir", "/flag", "-force=false", "-profile", "work", "-profile", "server", "-l", "dotlinks.json")
+	if i.Dir != "/flag" {
+		t.Errorf("Dir = %q, want the flag's /flag", i.Dir)
+	}
+	if i.Force {
+		t.Error("-force=false didn't override the file")
+	}
+	if i.Timeout != 5*time.Second {
+		t.Errorf("Timeout = %v, want the file's 5s", i.Timeout)
+	}
+	// A list flag replaces the file's values, the others are kept.
+	if want := []string{"work", "server"}; !reflect.DeepEqual(i.Profile, want) {
+		t.Errorf("Profile = %q, want %q", i.Profile, want)
+	}
+	if want := []string{"dotlinks.json"}; !reflect.DeepEqual(i.LinkFile, want) {
+		t.Errorf("LinkFile = %q, want %q", i.LinkFile, want)
+	}
+	if want := []string{"vim"}; !reflect.DeepEqual(i.Only, want) {
+		t.Errorf("Only = %q, want the file's %q", i.Only, want)
+	}
+}
+
+func TestLoadConfigHome(t *testing.T) {
+	t.Setenv(DotEnv, "")
+	t.Chdir(t.TempDir())
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	writeFile(t, filepath.Join(home, ".bootstraprc"), "quiet: true\n")
+	fs := flag.NewFlagSet("bootstrap", flag.ContinueOnError)
+	i, err := LoadConfig(fs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !i.Quiet {
+		t.Error("the config file in the home directory wasn't used")
+	}
+}
+
+func TestLoadConfigHomeFlag(t *testing.T) {
+	t.Setenv(DotEnv, "")
+	t.Chdir(t.TempDir())
+	home, other := t.TempDir(), t.TempDir()
+	t.Setenv("HOME", home)
+	writeFile(t, filepath.Join(home, ".bootstraprc"), "quiet: true\n")
+	writeFile(t, filepath.Join(other, ".bootstraprc"), "force: true\n")
+	fs := flag.NewFlagSet("bootstrap", flag.ContinueOnError)
+	i, err := LoadConfig(fs, []string{"-home", other})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.Quiet || !i.Force || i.HomeDir != other {
+		t.Errorf("LoadConfig with -home returned %+v, want the config file in %v used", i, other)
+	}
+}
+
+func TestLoadConfigUnknownOption(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeFile(t, ConfigFiles[0], "nope: true\n")
+	fs := flag.NewFlagSet("bootstrap", flag.ContinueOnError)
+	if _, err := LoadConfig(fs, nil); err == nil {
+		t.Error("an unknown option was accepted")
+	}
+}
+
+func TestPrintConfig(t *testing.T) {
+	t.Setenv(DotEnv, "env-dots")
+	i := loadConfig(t, "timeout: 5s\n", "-print-config", "-json")
+	var out bytes.Buffer
+	if _, err := Run(i, &out); err != nil {
+		t.Fatal(err)
+	}
+	var printed Input
+	if err := json.Unmarshal(out.Bytes(), &printed); err != nil {
+		t.Fatalf("the config isn't JSON: %v\n%v", err, out.String())
+	}
+	// The directory from the environment is made absolute.
+	wd, _ := os.Getwd()
+	if want := filepath.Join(wd, "env-dots"); printed.Dir != want {
+		t.Errorf("Dir = %q, want %q from $%v", printed.Dir, want, DotEnv)
+	}
+	if printed.Timeout != 5*time.Second {
+		t.Errorf("Timeout = %v, want the file's 5s", printed.Timeout)
+	}
+
+	// A flag overrides the environment, and the options are printed one per line without -json.
+	i = loadConfig(t, "", "-print-config", "-dir", "/flag")
+	out.Reset()
+	if _, err := Run(i, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains("\n"+out.String(), "\nDir: /flag\n") {
+		t.Errorf("the config doesn't hold Dir: /flag:\n%v", out.String())
+	}
+	// Nothing is linked.
+	if entries, _ := os.ReadDir(os.Getenv("HOME")); len(entries) > 0 {
+		t.Errorf("printing the config created %v", entries)
+	}
+}
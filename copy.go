@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// DefaultRetryDelay is how long Symlink waits before retrying a transient failure if the Input doesn't say.
+const DefaultRetryDelay = 100 * time.Millisecond
+
+// copyPath copies the file or directory at src to dest, keeping the permission bits of src. Directories are copied recursively. An error is returned if dest already exists.
+func copyPath(src, dest string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		err = copyFile(src, dest)
+		if err != nil {
+			return err
+		}
+		return os.Chmod(dest, info.Mode().Perm())
+	}
+	err = os.Mkdir(dest, 0755)
+	if err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		err = copyPath(filepath.Join(src, entry.Name()), filepath.Join(dest, entry.Name()))
+		if err != nil {
+			return err
+		}
+	}
+	// Change the mode last so a read only directory can still be filled.
+	return os.Chmod(dest, info.Mode().Perm())
+}
+
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// setOwnership applies the Mode and Owner of l to the copy at Dest. Not being allowed to change the owner, such as when not running as root, is only logged so the copy is kept.
+func (l Link) setOwnership() error {
+	if l.Mode != 0 {
+		if err := os.Chmod(l.Dest, l.Mode); err != nil {
+			return err
+		}
+	}
+	if l.Owner == "" {
+		return nil
+	}
+	uid, gid, err := lookupOwner(l.Owner)
+	if err != nil {
+		return err
+	}
+	err = os.Chown(l.Dest, uid, gid)
+	if errors.Is(err, fs.ErrPermission) {
+		l.logger().Warn("Not allowed to change the owner, run as root to do so", "dest", l.Dest, "owner", l.Owner)
+		return nil
+	}
+	return err
+}
+
+// lookupOwner returns the user and group IDs of owner, a user name or ID optionally followed by :group. The group ID is -1, leaving the group unchanged, if owner has no group.
+func lookupOwner(owner string) (uid, gid int, err error) {
+	name, group, _ := strings.Cut(owner, ":")
+	uid, err = strconv.Atoi(name)
+	if err != nil {
+		u, lerr := user.Lookup(name)
+		if lerr != nil {
+			return 0, 0, lerr
+		}
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return 0, 0, fmt.Errorf("the user %v has no numeric ID", name)
+		}
+	}
+	if group == "" {
+		return uid, -1, nil
+	}
+	gid, err = strconv.Atoi(group)
+	if err != nil {
+		g, lerr := user.LookupGroup(group)
+		if lerr != nil {
+			return 0, 0, lerr
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return 0, 0, fmt.Errorf("the group %v has no numeric ID", group)
+		}
+	}
+	return uid, gid, nil
+}
+
+// sameContents reports whether a and b are regular files with the same size and SHA-256 checksum.
+func sameContents(a, b string) (bool, error) {
+	ainfo, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	binfo, err := os.Lstat(b)
+	if err != nil {
+		return false, err
+	}
+	if !ainfo.Mode().IsRegular() || !binfo.Mode().IsRegular() || ainfo.Size() != binfo.Size() {
+		return false, nil
+	}
+	asum, err := checksum(a)
+	if err != nil {
+		return false, err
+	}
+	bsum, err := checksum(b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(asum, bsum), nil
+}
+
+// checksum returns the SHA-256 checksum of the file at path.
+func checksum(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// symlinkUnavailable reports whether err means symlinks can't be created at all, such as on Windows without developer mode.
+func symlinkUnavailable(err error) bool {
+	if errors.Is(err, errors.ErrUnsupported) {
+		return true
+	}
+	// ERROR_PRIVILEGE_NOT_HELD
+	var errno syscall.Errno
+	return runtime.GOOS == "windows" && errors.As(err, &errno) && errno == 1314
+}
+
+// retryable reports whether err may be transient, such as EIO on a network mount, so the operation is worth trying again. Errors that would happen again, such as the path already existing or permission being denied, are not.
+func retryable(err error) bool {
+	switch {
+	case errors.Is(err, fs.ErrExist), errors.Is(err, fs.ErrPermission), errors.Is(err, fs.ErrNotExist):
+		return false
+	case errors.Is(err, syscall.ENOTDIR), errors.Is(err, syscall.EROFS), errors.Is(err, syscall.ENAMETOOLONG):
+		return false
+	}
+	return !symlinkUnavailable(err)
+}
+
+// retry calls f until it succeeds, fails with an error that isn't retryable or has been retried retries times, logging each retry to logger. The delay before the first retry is doubled before each of the next.
+func retry(retries int, delay time.Duration, logger *slog.Logger, f func() error) error {
+	if delay <= 0 {
+		delay = DefaultRetryDelay
+	}
+	err := f()
+	for n := 0; n < retries && err != nil && retryable(err); n++ {
+		logger.Debug("Retrying", "delay", delay, "err", err)
+		time.Sleep(delay)
+		delay *= 2
+		err = f()
+	}
+	return err
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCopyPathFile(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "gitconfig")
+	writeFile(t, src, "[user]\n")
+	dest := filepath.Join(dir, "copy")
+	err := copyPath(src, dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "[user]\n" {
+		t.Errorf("the copy holds %q, want %q", data, "[user]\n")
+	}
+	if err := copyPath(src, dest); err == nil {
+		t.Error("copying over an existing file succeeded")
+	}
+}
+
+func TestCopyPathDir(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "nvim")
+	files := map[string]string{
+		"init.vim":           "set number",
+		"lua/plugins.lua":    "return {}",
+		"lua/config/lsp.lua": "-- lsp",
+	}
+	for name, contents := range files {
+		writeFile(t, filepath.Join(src, name), contents)
+	}
+	dest := filepath.Join(dir, "copy")
+	err := copyPath(src, dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range files {
+		data, err := os.ReadFile(filepath.Join(dest, name))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(data) != contents {
+			t.Errorf("the copy of %v holds %q, want %q", name, data, contents)
+		}
+	}
+	info, err := os.Lstat(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.IsDir() {
+		t.Errorf("the copy is %v, want a directory", info.Mode())
+	}
+}
+
+func TestSymlinkCopy(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "gitconfig")
+	writeFile(t, src, "[user]\n")
+	l := Link{Src: src, Dest: filepath.Join(dir, ".gitconfig")}
+	err := l.Symlink(Input{Copy: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !l.Copied {
+		t.Error("Copied is not set")
+	}
+	info, err := os.Lstat(l.Dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Mode().IsRegular() {
+		t.Errorf("the destination is %v, want a regular file", info.Mode())
+	}
+}
+
+func TestSameContents(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a"), "same")
+	writeFile(t, filepath.Join(dir, "b"), "same")
+	writeFile(t, filepath.Join(dir, "c"), "diff")
+	writeFile(t, filepath.Join(dir, "d"), "longer")
+	tests := []struct {
+		a, b string
+		want bool
+		err  bool
+	}{
+		{"a", "b", true, false},
+		{"a", "c", false, false},
+		{"a", "d", false, false},
+		{"a", "missing", false, true},
+	}
+	for _, test := range tests {
+		same, err := sameContents(filepath.Join(dir, test.a), filepath.Join(dir, test.b))
+		if same != test.want || (err != nil) != test.err {
+			t.Errorf("sameContents(%v, %v) = %v, %v", test.a, test.b, same, err)
+		}
+	}
+}
+
+func TestSymlinkCopyUnchanged(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "gitconfig")
+	writeFile(t, src, "[user]\n")
+	l := Link{Src: src, Dest: filepath.Join(dir, ".gitconfig")}
+	writeFile(t, l.Dest, "[user]\n")
+	if err := l.Symlink(Input{Copy: true}); err != ErrCopied {
+		t.Errorf("copying over the same contents returned %v, want ErrCopied", err)
+	}
+	// Different contents are replaced when forced.
+	writeFile(t, l.Dest, "[core]\n")
+	if err := l.Symlink(Input{Copy: true, Force: true}); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(l.Dest); err != nil || string(data) != "[user]\n" {
+		t.Errorf("the copy holds %q, %v", data, err)
+	}
+}
+
+func TestCopyPathModes(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "bin")
+	writeFile(t, filepath.Join(src, "prune"), "#!/bin/sh\n")
+	writeFile(t, filepath.Join(src, "notes"), "")
+	if err := os.Chmod(filepath.Join(src, "prune"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(src, "notes"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(src, 0700); err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(dir, "copy")
+	if err := copyPath(src, dest); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]os.FileMode{"": 0700, "prune": 0755, "notes": 0600} {
+		info, err := os.Stat(filepath.Join(dest, name))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if got := info.Mode().Perm(); got != want {
+			t.Errorf("the copy of %q has the mode %v, want %v", name, got, want)
+		}
+	}
+}
+
+// failing returns a function failing with errs in turn, then succeeding, and the number of times it was called.
+func failing(errs ...error) (func() error, *int) {
+	calls := new(int)
+	return func() error {
+		*calls++
+		if *calls <= len(errs) {
+			return errs[*calls-1]
+		}
+		return nil
+	}, calls
+}
+
+func TestRetry(t *testing.T) {
+	eio := &os.LinkError{Op: "symlink", Old: "a", New: "b", Err: syscall.EIO}
+	tests := []struct {
+		name    string
+		retries int
+		errs    []error
+		calls   int
+		fail    bool
+	}{
+		{"transient", 3, []error{eio, eio}, 3, false},
+		{"too many", 1, []error{eio, eio}, 2, true},
+		{"no retries", 0, []error{eio}, 1, true},
+		{"exists", 3, []error{&os.LinkError{Op: "symlink", Old: "a", New: "b", Err: syscall.EEXIST}}, 1, true},
+		{"permission", 3, []error{&os.LinkError{Op: "symlink", Old: "a", New: "b", Err: syscall.EACCES}}, 1, true},
+	}
+	for _, test := range tests {
+		f, calls := failing(test.errs...)
+		start := time.Now()
+		r := &records{}
+		err := retry(test.retries, time.Millisecond, slog.New(r), f)
+		if *calls != test.calls || (err != nil) != test.fail {
+			t.Errorf("%v: retry called f %v times and returned %v, want %v calls", test.name, *calls, err, test.calls)
+		}
+		if len(r.msgs) != test.calls-1 {
+			t.Errorf("%v: retry logged %q, want each of the %v retries", test.name, r.msgs, test.calls-1)
+		}
+		// The delay doubles before each retry.
+		if min := time.Duration(1<<(test.calls-1)-1) * time.Millisecond; time.Since(start) < min {
+			t.Errorf("%v: retry took %v, want at least %v", test.name, time.Since(start), min)
+		}
+	}
+}
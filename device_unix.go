@@ -0,0 +1,23 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// sameDevice reports whether the paths a and b are on the same filesystem, comparing the device IDs of their stat results.
+func sameDevice(a, b string) (bool, error) {
+	ainfo, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	binfo, err := os.Stat(b)
+	if err != nil {
+		return false, err
+	}
+	astat, aok := ainfo.Sys().(*syscall.Stat_t)
+	bstat, bok := binfo.Sys().(*syscall.Stat_t)
+	return aok && bok && astat.Dev == bstat.Dev, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// doctorCheck is a single item of the doctor checklist. Problems describes each failure found, Hint how to fix them.
+type doctorCheck struct {
+	Name     string
+	Problems []string
+	Hint     string
+}
+
+// doctor checks the setup described by i for the problems new users run into most, without changing anything, and writes a checklist of the results to w. An error is returned if any of the checks fail.
+func doctor(i Input, w io.Writer) error {
+	var checks []doctorCheck
+
+	// The source directories
+	check := doctorCheck{Name: "The source directory is set", Hint: fmt.Sprintf("Set $%v to your dotfiles directory, or pass -dir.", DotEnv)}
+	dir := i.Dir
+	if dir == "" {
+		check.Problems = append(check.Problems, fmt.Sprintf("$%v is not set, using the working directory", DotEnv))
+		dir = "."
+	}
+	checks = append(checks, check)
+
+	b := i.bootstrap()
+	check = doctorCheck{Name: "The source directories are readable", Hint: fmt.Sprintf("Check that $%v or -dir names existing directories you can read.", DotEnv)}
+	var roots []string
+	for _, root := range strings.Split(dir, ",") {
+		root, err := filepath.Abs(root)
+		if err == nil {
+			_, err = os.ReadDir(root)
+		}
+		if err != nil {
+			check.Problems = append(check.Problems, err.Error())
+			continue
+		}
+		roots = append(roots, root)
+		if i.Mirror {
+			b.AddMirror(root)
+		} else if err = b.Walk(root); err != nil {
+			check.Problems = append(check.Problems, err.Error())
+		}
+	}
+	for _, err := range b.Warnings {
+		check.Problems = append(check.Problems, err.Error())
+	}
+	checks = append(checks, check)
+
+	// The links files
+	check = doctorCheck{Name: "Links files are found", Hint: "Add a links.json next to the files to link, or pass -linkfile if yours are named differently."}
+	if len(b.DotDirs) == 0 {
+		check.Problems = append(check.Problems, fmt.Sprintf("no links files under %v", strings.Join(roots, ", ")))
+	}
+	checks = append(checks, check)
+
+	var links []Link
+	check = doctorCheck{Name: "The links files can be read", Hint: "Fix the syntax of the links files, or the environment variables they use."}
+	for _, dotDir := range b.DotDirs {
+		l, err := b.readLinks(dotDir)
+		if err != nil {
+			check.Problems = append(check.Problems, err.Error())
+			continue
+		}
+		links = append(links, l...)
+	}
+	checks = append(checks, check)
+
+	// The links
+	check = doctorCheck{Name: "The sources exist", Hint: "Create the sources, or remove their entries from the links files."}
+	for _, link := range links {
+		if _, err := os.Lstat(link.Src); err != nil {
+			check.Problems = append(check.Problems, fmt.Sprintf("%v is missing", link.Src))
+		}
+	}
+	checks = append(checks, check)
+
+	check = doctorCheck{Name: "The destinations don't conflict", Hint: "Link each destination from a single source, and move the existing files aside or run with -force."}
+	srcs := map[string]string{}
+	for _, link := range links {
+		if src, ok := srcs[link.Dest]; ok {
+			if src != link.Src {
+				check.Problems = append(check.Problems, fmt.Sprintf("%v and %v both link to %v", src, link.Src, link.Dest))
+			}
+			continue
+		}
+		srcs[link.Dest] = link.Src
+		if err := link.selfLink(); err != nil {
+			check.Problems = append(check.Problems, err.Error())
+			continue
+		}
+		switch state := link.State(); state {
+		case StateBlocked, StateWrongLink, StateBroken:
+			check.Problems = append(check.Problems, fmt.Sprintf("%v: %v", link.Dest, state))
+		}
+	}
+	checks = append(checks, check)
+
+	failed := 0
+	for _, check := range checks {
+		if len(check.Problems) == 0 {
+			fmt.Fprintf(w, "[ok]   %v\n", check.Name)
+			continue
+		}
+		failed++
+		fmt.Fprintf(w, "[fail] %v\n", check.Name)
+		sort.Strings(check.Problems)
+		for _, problem := range check.Problems {
+			fmt.Fprintf(w, "       %v\n", problem)
+		}
+		fmt.Fprintf(w, "       %v\n", check.Hint)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%v of %v checks failed", failed, len(checks))
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// healthyDir returns a dotfiles directory without any problems, along with an empty home directory.
+func healthyDir(t *testing.T) (dir, home string) {
+	t.Helper()
+	dir, home = t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(dir, "vimrc"), "")
+	writeFile(t, filepath.Join(dir, "links.json"), `{"vimrc": "~/.vimrc"}`)
+	return dir, home
+}
+
+func TestDoctor(t *testing.T) {
+	dir, home := healthyDir(t)
+	var out bytes.Buffer
+	if err := doctor(testInput(dir, home), &out); err != nil {
+		t.Errorf("doctor failed on a healthy setup: %v\n%v", err, out.String())
+	}
+	if strings.Contains(out.String(), "[fail]") || strings.Count(out.String(), "[ok]") != 6 {
+		t.Errorf("the checklist of a healthy setup is\n%v", out.String())
+	}
+}
+
+func TestDoctorProblems(t *testing.T) {
+	tests := []struct {
+		check string
+		setup func(t *testing.T, i *Input)
+	}{
+		{"The source directory is set", func(t *testing.T, i *Input) {
+			t.Chdir(i.Dir)
+			i.Dir = ""
+		}},
+		{"The source directories are readable", func(t *testing.T, i *Input) {
+			i.Dir = filepath.Join(i.Dir, "missing")
+		}},
+		{"Links files are found", func(t *testing.T, i *Input) {
+			i.Dir = t.TempDir()
+		}},
+		{"The links files can be read", func(t *testing.T, i *Input) {
+			writeFile(t, filepath.Join(i.Dir, "links.json"), `{"vimrc": `)
+		}},
+		{"The sources exist", func(t *testing.T, i *Input) {
+			writeFile(t, filepath.Join(i.Dir, "links.json"), `{"vimrc": "~/.vimrc", "zshrc": "~/.zshrc"}`)
+		}},
+		{"The destinations don't conflict", func(t *testing.T, i *Input) {
+			writeFile(t, filepath.Join(i.HomeDir, ".vimrc"), "mine")
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.check, func(t *testing.T) {
+			dir, home := healthyDir(t)
+			i := testInput(dir, home)
+			test.setup(t, &i)
+			var out bytes.Buffer
+			if err := doctor(i, &out); err == nil {
+				t.Error("doctor succeeded")
+			}
+			if !strings.Contains(out.String(), "[fail] "+test.check+"\n") {
+				t.Errorf("the check didn't fail:\n%v", out.String())
+			}
+		})
+	}
+}
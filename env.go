@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// loadEnvFiles sets the environment variables defined in each of the env files in turn, so the values of later files override those of earlier ones. Each variable set is logged to logger.
+func loadEnvFiles(paths []string, logger *slog.Logger) error {
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		vars, err := decodeEnv(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("parsing %v: %v", path, err)
+		}
+		for _, kv := range vars {
+			logger.Debug("Setting a variable", "name", kv[0], "file", path)
+			err = os.Setenv(kv[0], kv[1])
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// decodeEnv parses the KEY=VALUE lines of an env file in order, so later values of a key override earlier ones. Blank lines, comments and a leading "export " are skipped. Values may be single or double quoted like YAML scalars, and unquoted values end at the first " #".
+func decodeEnv(r io.Reader) ([][2]string, error) {
+	var vars [][2]string
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("line %v: expected KEY=VALUE", n)
+		}
+		value, rest, err := yamlScalar(strings.TrimSpace(value), false)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", n, err)
+		}
+		if rest = strings.TrimSpace(rest); rest != "" && !strings.HasPrefix(rest, "#") {
+			return nil, fmt.Errorf("line %v: unexpected %q", n, rest)
+		}
+		vars = append(vars, [2]string{key, value})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return vars, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeEnv(t *testing.T) {
+	vars, err := decodeEnv(strings.NewReader(`# The dotfiles
+DOTS=/home/test/dots
+
+export EDITOR=vim
+QUOTED="a # b"
+SINGLE='$NOT_EXPANDED'
+TRAILING=value # a comment
+DOTS=/home/test/other
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][2]string{
+		{"DOTS", "/home/test/dots"},
+		{"EDITOR", "vim"},
+		{"QUOTED", "a # b"},
+		{"SINGLE", "$NOT_EXPANDED"},
+		{"TRAILING", "value"},
+		{"DOTS", "/home/test/other"},
+	}
+	if !reflect.DeepEqual(vars, want) {
+		t.Errorf("decodeEnv = %q, want %q", vars, want)
+	}
+}
+
+func TestDecodeEnvErrors(t *testing.T) {
+	for _, data := range []string{"NOVALUE", "=value", "TWO WORDS=x", `QUOTED="open`, `QUOTED="a" b`} {
+		if _, err := decodeEnv(strings.NewReader(data)); err == nil || !strings.HasPrefix(err.Error(), "line 1: ") {
+			t.Errorf("decodeEnv(%q) returned %v, want an error on line 1", data, err)
+		}
+	}
+}
+
+func TestRunEnvFile(t *testing.T) {
+	dir, home := t.TempDir(), t.TempDir()
+	// Restore the variables the env files set.
+	t.Setenv("VIM_DIR", "")
+	writeFile(t, filepath.Join(dir, "vimrc"), "")
+	writeFile(t, filepath.Join(dir, "links.json"), `{"vimrc": "$VIM_DIR/.vimrc"}`)
+	writeFile(t, filepath.Join(dir, "first.env"), "VIM_DIR=/nowhere\n")
+	writeFile(t, filepath.Join(dir, "second.env"), "VIM_DIR="+home+"/vim\n")
+	i := testInput(dir, home)
+	i.EnvFile = []string{filepath.Join(dir, "first.env"), filepath.Join(dir, "second.env")}
+	i.MkdirAll = true
+	run(t, i)
+	// The later file wins.
+	if target, err := os.Readlink(filepath.Join(home, "vim", ".vimrc")); err != nil || target != filepath.Join(dir, "vimrc") {
+		t.Errorf("the destination using the env file links to %q, %v", target, err)
+	}
+}
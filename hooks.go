@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// HookError is returned by RunHooks when a hook command fails.
+type HookError struct {
+	Dir     string
+	Command string
+	// Output is the combined stdout and stderr of the command.
+	Output string
+	Err    error
+}
+
+func (e *HookError) Error() string {
+	msg := fmt.Sprintf("hook %q in %v: %v", e.Command, e.Dir, e.Err)
+	if e.Output != "" {
+		msg += ": " + e.Output
+	}
+	return msg
+}
+
+func (e *HookError) Unwrap() error { return e.Err }
+
+// HookRun is a hook command that succeeded.
+type HookRun struct {
+	Dir     string
+	Phase   string
+	Command string
+	// Output is the combined stdout and stderr of the command.
+	Output string
+}
+
+func (r HookRun) String() string {
+	return fmt.Sprintf("%v hook %q in %v: %v", r.Phase, r.Command, r.Dir, r.Output)
+}
+
+// RunHooks runs the commands of the phase, "pre" or "post", from hooks as returned by Read, so the links files aren't read again. The commands are run in order by the shell with Path as the working directory. Those that succeed are returned with their output, and a HookError for the first one that fails.
+func (d DotDir) RunHooks(phase string, hooks map[string][]string) ([]HookRun, error) {
+	var ran []HookRun
+	for _, command := range hooks[phase] {
+		cmd := hookCommand(command)
+		cmd.Dir = d.Path
+		out, err := cmd.CombinedOutput()
+		output := strings.TrimSpace(string(out))
+		if err != nil {
+			return ran, &HookError{Dir: d.Path, Command: command, Output: output, Err: err}
+		}
+		d.logger().Info("Ran hook", "phase", phase, "command", command, "dir", d.Path)
+		ran = append(ran, HookRun{Dir: d.Path, Phase: phase, Command: command, Output: output})
+	}
+	return ran, nil
+}
+
+// hookCommand returns the command running the hook with the system shell.
+func hookCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("sh", "-c", command)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// readHooks returns the hooks of d, failing t if its links files can't be read.
+func readHooks(t *testing.T, d DotDir) map[string][]string {
+	t.Helper()
+	_, hooks, err := d.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hooks
+}
+
+func TestRunHooks(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "links.json"), `{"hooks": {"post": ["true", "echo ran > ran", "echo done"]}}`)
+	writeFile(t, filepath.Join(dir, "links.local.json"), `{"hooks": {"post": ["echo local"]}}`)
+	d := DotDir{Path: dir, LinkFiles: []string{filepath.Join(dir, "links.json"), filepath.Join(dir, "links.local.json")}}
+	hooks := readHooks(t, d)
+	ran, err := d.RunHooks("post", hooks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The hooks run in the DotDir, those of the later links files last.
+	if _, err := os.Stat(filepath.Join(dir, "ran")); err != nil {
+		t.Errorf("the hook didn't run in %v: %v", dir, err)
+	}
+	var outputs []string
+	for _, r := range ran {
+		outputs = append(outputs, r.Command+": "+r.Output)
+	}
+	if want := []string{"true: ", "echo ran > ran: ", "echo done: done", "echo local: local"}; !reflect.DeepEqual(outputs, want) {
+		t.Errorf("RunHooks ran %q, want %q", outputs, want)
+	}
+	if ran, err := d.RunHooks("pre", hooks); err != nil || len(ran) > 0 {
+		t.Errorf("running a phase without hooks returned %v, %v", ran, err)
+	}
+}
+
+func TestRunHooksFailure(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "links.json"), `{"hooks": {"post": ["echo oops; false", "echo ran > ran"]}}`)
+	d := DotDir{Path: dir, LinkFiles: []string{filepath.Join(dir, "links.json")}}
+	_, err := d.RunHooks("post", readHooks(t, d))
+	var herr *HookError
+	if !errors.As(err, &herr) {
+		t.Fatalf("RunHooks returned %v, want a *HookError", err)
+	}
+	if herr.Dir != dir || herr.Command != "echo oops; false" || herr.Output != "oops" {
+		t.Errorf("RunHooks returned %+v", herr)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ran")); !os.IsNotExist(err) {
+		t.Error("the hook after the failing one ran")
+	}
+}
+
+func TestRunPostHooks(t *testing.T) {
+	dir, home := t.TempDir(), t.TempDir()
+	t.Setenv("HOME", home)
+	writeFile(t, filepath.Join(dir, "fonts", "font.ttf"), "")
+	writeFile(t, filepath.Join(dir, "fonts", "links.json"), `{"font.ttf": "~/font.ttf", "hooks": {"post": ["false"]}}`)
+	writeFile(t, filepath.Join(dir, "vim", "vimrc"), "")
+	writeFile(t, filepath.Join(dir, "vim", "links.json"), `{"vimrc": "~/.vimrc", "hooks": {"post": ["ls $HOME/.vimrc > linked"]}}`)
+	summary, out := run(t, testInput(dir, home))
+	// A failing hook doesn't stop the other DotDirs, which run theirs once linked.
+	if summary.Created != 2 || summary.Failed != 1 {
+		t.Errorf("Run returned %+v, want 2 created and 1 failed", summary)
+	}
+	if !strings.Contains(out, "Hook failures:") || !strings.Contains(out, `hook "false" in `+filepath.Join(dir, "fonts")) {
+		t.Errorf("the report doesn't list the failing hook:\n%v", out)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "vim", "linked")); err != nil {
+		t.Errorf("the hook of the other DotDir didn't run after linking: %v", err)
+	}
+}
+
+func TestRunPreHooks(t *testing.T) {
+	dir, home := t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(dir, "zsh", "zshrc"), "")
+	writeFile(t, filepath.Join(dir, "zsh", "links.json"), `{"zshrc": "~/.zshrc", "hooks": {"pre": ["false"], "post": ["echo ran > ran"]}}`)
+	writeFile(t, filepath.Join(dir, "vim", "vimrc"), "")
+	writeFile(t, filepath.Join(dir, "vim", "links.json"), `{"vimrc": "~/.vimrc", "hooks": {"pre": ["true"]}}`)
+	summary, out := run(t, testInput(dir, home))
+	if summary.Created != 1 || summary.Failed != 1 {
+		t.Errorf("Run returned %+v, want 1 created and 1 failed", summary)
+	}
+	// The links of the DotDir with the failing pre hook are skipped, and its post hooks aren't run.
+	if _, err := os.Lstat(filepath.Join(home, ".zshrc")); !os.IsNotExist(err) {
+		t.Error("the link blocked by the pre hook was created")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "zsh", "ran")); !os.IsNotExist(err) {
+		t.Error("the post hook of the blocked DotDir ran")
+	}
+	if !strings.Contains(out, "Blocked:\n"+filepath.Join(dir, "zsh", "zshrc")) {
+		t.Errorf("the report doesn't list the blocked link:\n%v", out)
+	}
+	if _, err := os.Lstat(filepath.Join(home, ".vimrc")); err != nil {
+		t.Errorf("the link with a passing pre hook wasn't created: %v", err)
+	}
+}
+
+func TestRunHooksUnreadable(t *testing.T) {
+	dir, home := t.TempDir(), t.TempDir()
+	vim := filepath.Join(dir, "vim")
+	writeFile(t, filepath.Join(vim, "vimrc"), "")
+	writeFile(t, filepath.Join(vim, "links.json"), `{"vimrc": "~/.vimrc", "hooks": {"pre": ["echo > pre"], "post": ["echo > post"]}}`)
+	writeFile(t, filepath.Join(vim, "links.local.json"), `{"vimrc": `)
+	i := testInput(dir, home)
+	i.Force = true
+	var out bytes.Buffer
+	summary, _ := Run(i, &out)
+	// The hooks of a DotDir whose links can't be read are skipped, and the error only reported once.
+	for _, name := range []string{"pre", "post"} {
+		if _, err := os.Stat(filepath.Join(vim, name)); !os.IsNotExist(err) {
+			t.Errorf("the %v hook ran", name)
+		}
+	}
+	if summary.Errors != 1 || summary.Failed != 0 || strings.Count(out.String(), "links.local.json") != 1 {
+		t.Errorf("Run returned %+v, want the error reading the links reported once:\n%v", summary, out.String())
+	}
+}
+
+func TestRunHookOutput(t *testing.T) {
+	dir, home := t.TempDir(), t.TempDir()
+	vim := filepath.Join(dir, "vim")
+	writeFile(t, filepath.Join(vim, "vimrc"), "")
+	writeFile(t, filepath.Join(vim, "links.json"), `{"vimrc": "~/.vimrc", "hooks": {"pre": ["echo fetched"], "post": ["echo installed 3 plugins"]}}`)
+	_, out := run(t, testInput(dir, home))
+	// The output of the hooks that succeed is reported too.
+	for _, want := range [][2]string{
+		{`pre hook "echo fetched" in ` + vim, ": fetched\n"},
+		{`post hook "echo installed 3 plugins" in ` + vim, ": installed 3 plugins\n"},
+	} {
+		if !strings.Contains(out, "Hook output:\n") || !strings.Contains(out, want[0]) || !strings.Contains(out, want[1]) {
+			t.Errorf("the report doesn't hold %q:\n%v", want[0]+want[1], out)
+		}
+	}
+}
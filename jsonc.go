@@ -0,0 +1,64 @@
+package main
+
+// stripJSONC returns the JSON in data without the // line comments, /* */ block comments and trailing commas allowed by JSONC. Comments are replaced by spaces, keeping the line numbers and offsets of decoding errors.
+func stripJSONC(data []byte) []byte {
+	out := make([]byte, len(data))
+	copy(out, data)
+
+	// Blank out the comments.
+	inString := false
+	for n := 0; n < len(out); n++ {
+		switch {
+		case inString:
+			if out[n] == '\\' {
+				n++
+			} else if out[n] == '"' {
+				inString = false
+			}
+		case out[n] == '"':
+			inString = true
+		case out[n] == '/' && n+1 < len(out) && out[n+1] == '/':
+			for ; n < len(out) && out[n] != '\n'; n++ {
+				out[n] = ' '
+			}
+		case out[n] == '/' && n+1 < len(out) && out[n+1] == '*':
+			out[n], out[n+1] = ' ', ' '
+			for n += 2; n < len(out) && !(out[n] == '*' && n+1 < len(out) && out[n+1] == '/'); n++ {
+				if out[n] != '\n' {
+					out[n] = ' '
+				}
+			}
+			if n < len(out) {
+				out[n], out[n+1] = ' ', ' '
+				n++
+			}
+		}
+	}
+
+	// Blank out the commas followed only by whitespace before a closing bracket.
+	inString = false
+	comma := -1
+	for n := 0; n < len(out); n++ {
+		switch c := out[n]; {
+		case inString:
+			if c == '\\' {
+				n++
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+			comma = -1
+		case c == ',':
+			comma = n
+		case c == '}' || c == ']':
+			if comma >= 0 {
+				out[comma] = ' '
+			}
+			comma = -1
+		case c != ' ' && c != '\t' && c != '\n' && c != '\r':
+			comma = -1
+		}
+	}
+	return out
+}
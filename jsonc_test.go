@@ -0,0 +1,44 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStripJSONC(t *testing.T) {
+	commented := `{
+	// The editor.
+	"vimrc": "~/.vimrc", /* also read by neovim */
+	"zshrc": {
+		"dest": "~/.zshrc",
+		"requires": ["zsh",], // trailing commas are fine
+	},
+	/* Comment markers in strings are kept. */
+	"url//*": "~/a//b/*c*/",
+}`
+	plain := `{
+	"vimrc": "~/.vimrc",
+	"zshrc": {"dest": "~/.zshrc", "requires": ["zsh"]},
+	"url//*": "~/a//b/*c*/"
+}`
+	got, err := decodeJSONLinks(strings.NewReader(commented))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := decodeJSONLinks(strings.NewReader(plain))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("the commented links file decodes to %+v, want %+v", got, want)
+	}
+}
+
+func TestStripJSONCOffsets(t *testing.T) {
+	data := "{\n/* a\nb */ \"a\": 1, // c\n}"
+	got := string(stripJSONC([]byte(data)))
+	if len(got) != len(data) || strings.Count(got, "\n") != strings.Count(data, "\n") {
+		t.Errorf("stripJSONC(%q) = %q, which moves the lines", data, got)
+	}
+}
@@ -1,286 +1,2109 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"os"
+	"os/exec"
+	"os/user"
+	"path"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"text/template"
+	"time"
 )
 
 // Input holds the user settable values.
 type Input struct {
-	Dir   string
-	Dry   bool
-	Force bool
+	Dir           string
+	Dry           bool
+	Force         bool
+	ForceDir      bool
+	Backup        bool
+	Status        bool
+	MkdirAll      bool
+	LinkFile      []string
+	Unlink        bool
+	JSON          bool
+	Relative      bool
+	Verbose       int
+	Quiet         bool
+	CheckSource   bool
+	Copy          bool
+	Prune         bool
+	StateFile     string
+	All           bool
+	NoColor       bool
+	Only          []string
+	Exclude       []string
+	Profile       []string
+	Timeout       time.Duration
+	Root          string
+	Interactive   bool
+	Since         bool
+	List          bool
+	AllowedEnv    []string
+	DirLinks      bool
+	Doctor        bool
+	HomeDir       string
+	Retries       int
+	RetryDelay    time.Duration
+	Mirror        bool
+	Hardlink      bool
+	AllowMissing  bool
+	Progress      bool
+	Trailer       string
+	SymlinksOnly  bool
+	LinkFileGlob  string
+	EnvFile       []string
+	BackupDir     string
+	DirsFrom      string
+	Follow        bool
+	ParallelDirs  int
+	ParallelLinks int
+	Verify        bool
+	MaxDepth      int
+	Sync          bool
+	PrintConfig   bool
+	MergeDirs     bool
+}
+
+// Home returns the home directory the paths are resolved against: HomeDir if set, otherwise $HOME.
+func (i Input) Home() string {
+	if i.HomeDir != "" {
+		return i.HomeDir
+	}
+	return os.Getenv("HOME")
+}
+
+// statePath returns the path of the state file: StateFile if set, otherwise the package StateFile in the home directory, under Root if it is set like the links.
+func (i Input) statePath() string {
+	if i.StateFile != "" {
+		return i.StateFile
+	}
+	l := Link{Dest: filepath.Join(i.Home(), StateFile)}
+	l.reroot(i.Root)
+	return l.Dest
+}
+
+// usesState reports whether a run reads and records the State, which is only the case with Prune, Since or Sync or an explicit StateFile.
+func (i Input) usesState() bool {
+	return i.Prune || i.Since || i.Sync || i.StateFile != ""
 }
 
 // Link is a single symlink. A source and destination are required
 type Link struct {
 	Src  string
 	Dest string
+	// BackupPath is set to the location the existing Dest was moved to by Backup.
+	BackupPath string
+	// CreatedDir is set to the top most parent directory of Dest created by Symlink.
+	CreatedDir string
+	// Force, MkdirAll and Copy enable the matching Input options for this link only.
+	Force    bool
+	MkdirAll bool
+	Copy     bool
+	// Copied is set by Symlink when Src was copied to Dest instead of linked.
+	Copied bool
+	// Hardlinked is set along with Copied when Src was hard linked to Dest instead of copied.
+	Hardlinked bool
+	// Requires are the commands that must be found in $PATH for the link to be created.
+	Requires []string
+	// Owner and Mode are applied to Dest when Src is copied, if set. Owner is a user name or ID, optionally followed by :group.
+	Owner string
+	Mode  os.FileMode
+	// Order is the position of the link among the others when they are applied, lower first.
+	Order int
+	// Merged is set on the links of the files in Src merged into an existing directory by Links, rather than replacing it.
+	Merged bool
+	// Logger receives the diagnostics of Symlink, such as the retries. Nothing is logged if nil.
+	Logger *slog.Logger
 }
 
 func (l Link) String() string {
+	if l.BackupPath != "" {
+		return fmt.Sprintf("%v -> %v (backup: %v)", l.Src, l.Dest, l.BackupPath)
+	}
 	return fmt.Sprintf("%v -> %v", l.Src, l.Dest)
 }
 
-func (l Link) cmd(force bool) string {
-	if force {
-		return fmt.Sprintf("ln -sf %v %v", l.Src, l.Dest)
+func (l Link) cmd(i Input) string {
+	i = l.input(i)
+	if i.Copy {
+		return fmt.Sprintf("cp -R %v %v", l.Src, l.Dest)
+	}
+	target, err := l.target(i.Relative)
+	if err != nil {
+		target = l.Src
+	}
+	if i.Force {
+		return fmt.Sprintf("ln -sf %v %v", target, l.Dest)
+	}
+	return fmt.Sprintf("ln -s %v %v", target, l.Dest)
+}
+
+// input returns i with the options set on the link enabled.
+func (l Link) input(i Input) Input {
+	i.Force = i.Force || l.Force
+	i.MkdirAll = i.MkdirAll || l.MkdirAll
+	i.Copy = i.Copy || l.Copy
+	return i
+}
+
+// target returns the path the symlink at Dest should contain. This is Src relative to the directory of Dest if relative is set.
+func (l Link) target(relative bool) (string, error) {
+	if !relative {
+		return l.Src, nil
+	}
+	return filepath.Rel(filepath.Dir(l.Dest), l.Src)
+}
+
+// readlink returns the target of the symlink at Dest. Relative targets are resolved against the directory of Dest.
+func (l Link) readlink() (string, error) {
+	target, err := os.Readlink(l.Dest)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(l.Dest), target)
+	}
+	return target, nil
+}
+
+// linked reports whether Dest is a symlink that resolves to Src, directly or through a chain of symlinks. A dangling chain is never linked.
+func (l Link) linked() bool {
+	target, err := l.readlink()
+	if err != nil {
+		return false
+	}
+	if target == l.Src {
+		return true
+	}
+	dest, err := filepath.EvalSymlinks(l.Dest)
+	if err != nil {
+		return false
+	}
+	src, err := filepath.EvalSymlinks(l.Src)
+	return err == nil && dest == src
+}
+
+// reroot moves Dest under root, dropping any volume name. Nothing changes if root is empty.
+func (l *Link) reroot(root string) {
+	if root == "" {
+		return
+	}
+	l.Dest = filepath.Join(root, strings.TrimPrefix(l.Dest, filepath.VolumeName(l.Dest)))
+}
+
+// Clean replaces the environment variables anywhere in the source and destination paths with the values.
+func (l *Link) Clean() {
+	l.clean("", nil)
+}
+
+// clean is Clean, but ~ and $HOME are resolved to home unless it is empty, and an error is returned for the environment variables not in allowed unless it is nil.
+func (l *Link) clean(home string, allowed []string) error {
+	src, err := cleanPath(l.Src, home, allowed)
+	if err != nil {
+		return err
+	}
+	dest, err := cleanPath(l.Dest, home, allowed)
+	if err != nil {
+		return err
+	}
+	l.Src, l.Dest = src, dest
+	return nil
+}
+
+// cleanPath cleans the path, expanding a leading ~ or XDG token and the environment variables. The home directory is home, or $HOME if it is empty. An error is returned for the variables not in allowed unless it is nil.
+func cleanPath(path, home string, allowed []string) (string, error) {
+	var err error
+	expand := func(name string) string {
+		if allowed != nil && !envAllowed(name, allowed) && err == nil {
+			err = fmt.Errorf("%v: the environment variable %v is not allowed", path, strings.SplitN(name, ":-", 2)[0])
+		}
+		if home != "" && strings.SplitN(name, ":-", 2)[0] == "HOME" {
+			return home
+		}
+		return expandVar(name)
+	}
+	// Only the first element can be a ~ or XDG token, never the value of a variable.
+	first, _, _ := strings.Cut(path, "/")
+	if strings.HasPrefix(first, "~") {
+		path = expandTilde(first, home) + path[len(first):]
+	} else if strings.HasPrefix(first, "@") {
+		path = expandXDG(first, home) + path[len(first):]
+	}
+	// Expand the whole path before cleaning it, so a default such as ${NAME:-a/b} keeps its slash.
+	if strings.Contains(path, "$") {
+		path = os.Expand(path, expand)
+	}
+	if err != nil {
+		return "", err
+	}
+	return filepath.Clean(path), nil
+}
+
+// envAllowed reports whether the variable in name, which may be of the form NAME:-default, is one of allowed.
+func envAllowed(name string, allowed []string) bool {
+	if n := strings.Index(name, ":-"); n >= 0 {
+		name = name[:n]
+	}
+	for _, a := range allowed {
+		if a == name {
+			return true
+		}
+	}
+	return false
+}
+
+// escapable are the characters a backslash is taken to escape rather than separate from the previous path element.
+const escapable = ` \$*?[]{}'"`
+
+// normalizeSeparators replaces the backslashes used as separators in Windows style paths with slashes, reporting whether there were any. Backslashes escaping one of the escapable characters are kept. Nothing is replaced on Windows, where backslashes are the separator.
+func normalizeSeparators(path string) (string, bool) {
+	if filepath.Separator == '\\' || !strings.Contains(path, `\`) {
+		return path, false
+	}
+	var b strings.Builder
+	replaced := false
+	for n := 0; n < len(path); n++ {
+		if path[n] != '\\' {
+			b.WriteByte(path[n])
+			continue
+		}
+		if n+1 < len(path) && strings.IndexByte(escapable, path[n+1]) >= 0 {
+			b.WriteString(path[n : n+2])
+			n++
+			continue
+		}
+		b.WriteByte('/')
+		replaced = true
+	}
+	return b.String(), replaced
+}
+
+// expandVar returns the value of the environment variable name for os.Expand. A name of the form NAME:-default, from ${NAME:-default}, returns the default when the variable is unset or empty.
+func expandVar(name string) string {
+	if n := strings.Index(name, ":-"); n >= 0 {
+		if v := os.Getenv(name[:n]); v != "" {
+			return v
+		}
+		return name[n+2:]
+	}
+	return os.Getenv(name)
+}
+
+// expandTilde replaces ~ with home, or $HOME if it is empty, and ~user with the home directory of user. Unknown users are left untouched.
+func expandTilde(e, home string) string {
+	name := strings.TrimPrefix(e, "~")
+	if name == "" && home != "" {
+		return home
+	}
+	if name == "" {
+		return os.Getenv("HOME")
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return e
+	}
+	return u.HomeDir
+}
+
+// xdgDirs maps the tokens expanded by expandXDG to their XDG base directory variable and its default under the home directory.
+var xdgDirs = map[string][2]string{
+	"@config": {"XDG_CONFIG_HOME", ".config"},
+	"@data":   {"XDG_DATA_HOME", ".local/share"},
+	"@state":  {"XDG_STATE_HOME", ".local/state"},
+	"@cache":  {"XDG_CACHE_HOME", ".cache"},
+}
+
+// expandXDG replaces a token such as @config with the XDG base directory, e.g. $XDG_CONFIG_HOME or ~/.config under home if it is unset. Unknown tokens are left untouched.
+func expandXDG(e, home string) string {
+	dir, ok := xdgDirs[e]
+	if !ok {
+		return e
+	}
+	if v := os.Getenv(dir[0]); v != "" {
+		return v
+	}
+	return filepath.Join(expandTilde("~", home), dir[1])
+}
+
+// LinkState describes what currently exists at a Link's destination.
+type LinkState int
+
+const (
+	// StateMissing means nothing exists at Dest.
+	StateMissing LinkState = iota
+	// StateLinked means Dest is a symlink resolving to Src, directly or through other symlinks.
+	StateLinked
+	// StateWrongLink means Dest is a symlink to something other than Src.
+	StateWrongLink
+	// StateBroken means Dest is a symlink to something other than Src that doesn't exist.
+	StateBroken
+	// StateBlocked means Dest is a real file or directory.
+	StateBlocked
+	// StateUnknown means Dest could not be inspected.
+	StateUnknown
+)
+
+func (s LinkState) String() string {
+	switch s {
+	case StateMissing:
+		return "Missing"
+	case StateLinked:
+		return "Linked"
+	case StateWrongLink:
+		return "Wrong link"
+	case StateBroken:
+		return "Broken link"
+	case StateBlocked:
+		return "Blocked"
+	}
+	return "Unknown"
+}
+
+// State inspects Dest without modifying anything and reports how it relates to Src.
+func (l Link) State() LinkState {
+	return l.state(nil)
+}
+
+// state is State, looking Dest up in the cache unless it is nil.
+func (l Link) state(cache *statCache) LinkState {
+	mode, err := cache.lstat(l.Dest)
+	if os.IsNotExist(err) {
+		return StateMissing
+	}
+	if err != nil {
+		return StateUnknown
+	}
+	if mode&os.ModeSymlink == 0 {
+		return StateBlocked
+	}
+	// A chain of symlinks reaching Src is linked like a direct one, as Symlink sees it.
+	if l.linked() {
+		return StateLinked
+	}
+	if _, err := l.readlink(); err != nil {
+		return StateUnknown
+	}
+	if _, err := os.Stat(l.Dest); os.IsNotExist(err) {
+		return StateBroken
+	}
+	return StateWrongLink
+}
+
+// Diff describes what Dest currently is and what it would be once linked. The current value is the symlink target, "absent" if nothing exists or "file" for a real file or directory.
+func (l Link) Diff() (current, desired string) {
+	switch l.State() {
+	case StateMissing:
+		current = "absent"
+	case StateBlocked:
+		current = "file"
+	case StateUnknown:
+		current = "unknown"
+	default:
+		current, _ = l.readlink()
+	}
+	return current, l.Src
+}
+
+// BackupTimeFormat is the layout of the timestamp appended to backed up destinations.
+const BackupTimeFormat = "20060102T150405"
+
+// Verify returns an error if Dest isn't what Symlink made of it: a symlink resolving to Src or, if copied is set, a file with the contents of Src.
+func (l Link) Verify(copied bool) error {
+	if copied {
+		same, err := sameContents(l.Src, l.Dest)
+		if err != nil {
+			return err
+		}
+		if !same {
+			return fmt.Errorf("%v doesn't have the contents of %v", l.Dest, l.Src)
+		}
+		return nil
+	}
+	if l.linked() {
+		return nil
+	}
+	return fmt.Errorf("%v is %v instead of a link to %v", l.Dest, strings.ToLower(l.State().String()), l.Src)
+}
+
+// Preview returns what Symlink would do to Dest with the options i, without changing anything: "New" if nothing exists there, "Skip" if it is already linked or copied, "Replace" if it would be replaced and "Conflict" if it is in the way.
+func (l Link) Preview(i Input) string {
+	i = l.input(i)
+	if l.linked() {
+		return "Skip"
+	}
+	if i.Copy || l.Merged {
+		if same, err := sameContents(l.Src, l.Dest); err == nil && same {
+			return "Skip"
+		}
+	}
+	switch l.State() {
+	case StateMissing:
+		return "New"
+	case StateWrongLink, StateBroken:
+		if i.Force || i.SymlinksOnly {
+			return "Replace"
+		}
+	case StateBlocked:
+		info, err := os.Lstat(l.Dest)
+		if err != nil || i.SymlinksOnly {
+			break
+		}
+		if info.IsDir() && i.ForceDir || !info.IsDir() && i.Force {
+			return "Replace"
+		}
+	}
+	return "Conflict"
+}
+
+// Backup moves the existing Dest to the backupPath in dir, creating its missing parent directories under dir. The new location is stored in BackupPath. The error of os.Lstat is returned without creating anything if Dest can't be found.
+func (l *Link) Backup(dir string) error {
+	_, err := os.Lstat(l.Dest)
+	if err != nil {
+		return err
+	}
+	path := backupPath(l.Dest, dir)
+	// Next to Dest, the directory already exists.
+	if dir != "" {
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			return err
+		}
+	}
+	err = os.Rename(l.Dest, path)
+	if err != nil {
+		return err
+	}
+	l.BackupPath = path
+	return nil
+}
+
+// backupPath returns an unused path to back dest up to, appending a timestamp to the name. It is next to dest if dir is empty, otherwise under dir at the full path of dest, e.g. dir/home/user/.zshrc.bak.20060102T150405. A counter is appended if the path is already taken.
+func backupPath(dest, dir string) string {
+	base := dest
+	if dir != "" {
+		base = filepath.Join(dir, strings.TrimPrefix(dest, filepath.VolumeName(dest)))
+	}
+	base = fmt.Sprintf("%v.bak.%v", base, time.Now().Format(BackupTimeFormat))
+	path := base
+	for n := 1; ; n++ {
+		// A path that can't be found for another reason, such as dir being a file, is left for the move to fail on.
+		if _, err := os.Lstat(path); err != nil {
+			return path
+		}
+		path = fmt.Sprintf("%v.%v", base, n)
+	}
+}
+
+// LinkResult is the outcome of a single link. Err is nil if the link was successful.
+type LinkResult struct {
+	Link Link
+	Err  error
+	// dotDir is the index of the DotDir the link is from, so the results can be put back in the order of the DotDirs.
+	dotDir int
+}
+
+// MarshalJSON encodes the result as an object with src, dest and error fields.
+func (r LinkResult) MarshalJSON() ([]byte, error) {
+	v := struct {
+		Src   string `json:"src"`
+		Dest  string `json:"dest"`
+		Error string `json:"error"`
+	}{Src: r.Link.Src, Dest: r.Link.Dest}
+	if r.Err != nil {
+		v.Error = r.Err.Error()
+	}
+	return json.Marshal(v)
+}
+
+// ErrLinked is returned by Symlink when Dest is already a symlink to Src.
+var ErrLinked = errors.New("already linked")
+
+// ErrUnchanged is the error of a link skipped because its source hasn't changed since it was last linked or copied.
+var ErrUnchanged = errors.New("unchanged since the last run")
+
+// ErrDuplicate is the error of a link skipped because an earlier link has the same destination.
+var ErrDuplicate = errors.New("duplicate destination")
+
+// ErrCopied is returned by Symlink in copy mode when Dest is already a file with the same contents as Src.
+var ErrCopied = errors.New("already copied")
+
+// MkdirError is returned by Symlink when the parent directories of Dest could not be created.
+type MkdirError struct {
+	Dir string
+	Err error
+}
+
+func (e *MkdirError) Error() string {
+	return fmt.Sprintf("creating %v: %v", e.Dir, e.Err)
+}
+
+func (e *MkdirError) Unwrap() error {
+	return e.Err
+}
+
+// mkdirAll creates the missing parent directories of Dest. The top most directory created is stored in CreatedDir.
+func (l *Link) mkdirAll() error {
+	dir := filepath.Dir(l.Dest)
+	if info, err := os.Stat(dir); err == nil && info.IsDir() {
+		return nil
+	}
+	missing := ""
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil || !os.IsNotExist(err) {
+			break
+		}
+		missing = d
+		if d == filepath.Dir(d) {
+			break
+		}
+	}
+	// A file in the way of a parent directory makes MkdirAll fail.
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return &MkdirError{Dir: dir, Err: err}
+	}
+	l.CreatedDir = missing
+	return nil
+}
+
+// UnmetError is the error of a link skipped because the commands it requires aren't found in $PATH.
+type UnmetError struct {
+	Commands []string
+}
+
+func (e *UnmetError) Error() string {
+	return fmt.Sprintf("requires %v, not found in $PATH", strings.Join(e.Commands, ", "))
+}
+
+// unmet returns an UnmetError listing the Requires not found in $PATH, or nil if they all are.
+func (l Link) unmet() error {
+	var missing []string
+	for _, command := range l.Requires {
+		if _, err := exec.LookPath(command); err != nil {
+			missing = append(missing, command)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return &UnmetError{Commands: missing}
+}
+
+// ErrConflict is returned by Symlink when Dest is an existing file or directory that is not a symlink.
+type ErrConflict struct {
+	Dest string
+	Info os.FileInfo
+}
+
+func (e *ErrConflict) Error() string {
+	kind := "file"
+	if e.Info.IsDir() {
+		kind = "directory"
+	}
+	return fmt.Sprintf("%v is an existing %v", e.Dest, kind)
+}
+
+// BrokenLinkError is returned by Symlink when Dest is a dangling symlink to something other than Src.
+type BrokenLinkError struct {
+	Dest   string
+	Target string
+}
+
+func (e *BrokenLinkError) Error() string {
+	return fmt.Sprintf("%v is a broken link to %v", e.Dest, e.Target)
+}
+
+// ErrPermission is returned by Symlink when the current user can't write to Dir, the parent directory of Dest.
+type ErrPermission struct {
+	Dest string
+	Dir  string
+	Err  error
+}
+
+func (e *ErrPermission) Error() string {
+	return fmt.Sprintf("no permission to write to %v, run as a user who can, e.g. with sudo", e.Dir)
+}
+
+func (e *ErrPermission) Unwrap() error {
+	return e.Err
+}
+
+// MissingSourceError is returned by Symlink when CheckSource is set and Src doesn't exist.
+type MissingSourceError struct {
+	Src string
+	Err error
+}
+
+func (e *MissingSourceError) Error() string {
+	return fmt.Sprintf("source %v does not exist", e.Src)
+}
+
+func (e *MissingSourceError) Unwrap() error {
+	return e.Err
+}
+
+// Symlink creates a symlink at Dest to Src, replacing what is in the way only as far as the Input allows. ErrLinked is returned without changing anything if Dest already resolves to Src, even through other symlinks, and an ErrConflict, BrokenLinkError, ErrPermission or MissingSourceError if the link can't be created as asked.
+func (l *Link) Symlink(i Input) error {
+	// The Force, MkdirAll and Copy options of the link override the Input.
+	i = l.input(i)
+	if l.linked() {
+		return ErrLinked
+	}
+	// A copy, or a file merged into a directory, already holding the contents of Src is left as it is, only getting the Mode and Owner of a copy.
+	if i.Copy || l.Merged {
+		if same, err := sameContents(l.Src, l.Dest); err == nil && same {
+			if i.Copy {
+				if err := l.setOwnership(); err != nil {
+					return err
+				}
+			}
+			return ErrCopied
+		}
+	}
+	// Links to missing sources are allowed unless CheckSource is set.
+	if i.CheckSource {
+		if _, err := os.Lstat(l.Src); err != nil {
+			return &MissingSourceError{Src: l.Src, Err: err}
+		}
+	}
+	// Force replacing other symlinks, but never a real file or directory.
+	if i.SymlinksOnly {
+		if info, err := os.Lstat(l.Dest); err == nil && info.Mode()&os.ModeSymlink == 0 {
+			return &ErrConflict{Dest: l.Dest, Info: info}
+		}
+		i.Force, i.ForceDir = true, false
+	}
+	// Replace a dangling symlink to Src, such as a relative one left by a moved dotfiles directory. One to something else is only replaced if Force is set.
+	if target, err := l.readlink(); err == nil && !i.Force {
+		if _, err := os.Stat(l.Dest); os.IsNotExist(err) {
+			if filepath.Clean(target) != l.Src {
+				return &BrokenLinkError{Dest: l.Dest, Target: target}
+			}
+			err = os.Remove(l.Dest)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	// Directories are only replaced if ForceDir is set, files if Force is.
+	dir := false
+	if info, err := os.Lstat(l.Dest); err == nil && info.Mode()&os.ModeSymlink == 0 {
+		dir = info.IsDir()
+		if dir && !i.ForceDir || !dir && !i.Force {
+			return &ErrConflict{Dest: l.Dest, Info: info}
+		}
+	}
+	// Without MkdirAll, a missing parent directory fails the link.
+	if i.MkdirAll {
+		err := l.mkdirAll()
+		if err != nil {
+			return err
+		}
+	}
+	// Check the parent directory can be written to before removing anything from it.
+	parent := filepath.Dir(l.Dest)
+	if err := writable(parent); errors.Is(err, os.ErrPermission) {
+		return &ErrPermission{Dest: l.Dest, Dir: parent, Err: err}
+	}
+	// A forced file is replaced by renaming the new symlink over it, so Dest is never missing.
+	replace := i.Force && !dir && !i.Backup && !i.Copy
+	// Otherwise Dest is moved to the BackupDir if Backup is set, or removed.
+	if (i.Force || dir) && !replace {
+		var err error
+		switch {
+		case i.Backup:
+			err = l.Backup(i.BackupDir)
+		case dir:
+			err = os.RemoveAll(l.Dest)
+		default:
+			err = os.Remove(l.Dest)
+		}
+		// There is nothing to replace the first time a link is created
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	// Copy Src instead, or hard link it if Hardlink is set and it is a file on the same filesystem.
+	if i.Copy {
+		return l.copy(i.Hardlink)
+	}
+	// The target is relative to the directory of Dest if Relative is set.
+	target, err := l.target(i.Relative)
+	if err != nil {
+		return err
+	}
+	// Try again up to Retries times after a transient error, waiting RetryDelay before the first retry and twice as long before each of the next.
+	err = retry(i.Retries, i.RetryDelay, l.logger(), func() error {
+		if replace {
+			return l.replaceSymlink(target)
+		}
+		return os.Symlink(target, l.Dest)
+	})
+	if err != nil && symlinkUnavailable(err) {
+		// Fall back to copying when the system can't create symlinks.
+		if replace {
+			err = os.Remove(l.Dest)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		return l.copy(i.Hardlink)
+	}
+	return err
+}
+
+// replaceSymlink creates a symlink to target at a temporary path next to Dest and renames it over Dest, which replaces Dest atomically on Unix.
+func (l Link) replaceSymlink(target string) error {
+	tmp := filepath.Join(filepath.Dir(l.Dest), fmt.Sprintf(".%v.%v.tmp", filepath.Base(l.Dest), time.Now().UnixNano()))
+	err := os.Symlink(target, tmp)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp, l.Dest)
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// applied reports whether Dest is still a symlink to Src or, if copy is set, a file.
+func (l Link) applied(copy bool) bool {
+	if l.linked() {
+		return true
+	}
+	info, err := os.Lstat(l.Dest)
+	return copy && err == nil && info.Mode()&os.ModeSymlink == 0
+}
+
+// copy copies Src to Dest and sets Copied. If hardlink is set and Src is a file on the same filesystem as Dest, it is hard linked instead and Hardlinked is also set. Copying is the fallback if the hard link can't be created, and what is done if the link has a Mode or Owner, as a hard link shares them with Src.
+func (l *Link) copy(hardlink bool) error {
+	if hardlink && l.Mode == 0 && l.Owner == "" {
+		info, err := os.Stat(l.Src)
+		same := false
+		if err == nil && info.Mode().IsRegular() {
+			same, err = sameDevice(l.Src, filepath.Dir(l.Dest))
+		}
+		if err == nil && same {
+			err = os.Link(l.Src, l.Dest)
+			if err == nil {
+				l.Copied, l.Hardlinked = true, true
+				return nil
+			}
+		}
+		if err != nil {
+			l.logger().Debug("Copying instead of hard linking", "link", l, "err", err)
+		}
+	}
+	err := copyPath(l.Src, l.Dest)
+	if err != nil {
+		return err
+	}
+	l.Copied = true
+	return l.setOwnership()
+}
+
+// ErrNotLinked is returned by Unlink when Dest is not a symlink to Src.
+var ErrNotLinked = errors.New("not linked")
+
+// Unlink removes Dest if it is a symlink to Src. Nothing is removed unless apply is set, so a dry run reports the same results. ErrNotLinked is returned without changing anything if Dest is missing, not a symlink or points elsewhere.
+func (l Link) Unlink(apply bool) error {
+	target, err := l.readlink()
+	if err != nil || target != l.Src {
+		return ErrNotLinked
+	}
+	if !apply {
+		return nil
+	}
+	return os.Remove(l.Dest)
+}
+
+// DestSource is the token starting a source relative to the directory of its destination, such as "@dest/init.vim".
+const DestSource = "@dest"
+
+// DotDir is a directory containing one or more links files. The paths in the links files, if not absolute, will be relative to the Path attribute. Links files ending in .yaml or .yml are decoded as YAML, .toml as TOML, anything else as JSON.
+type DotDir struct {
+	Path      string
+	LinkFiles []string
+	// LinkFile is the name of the links file Walk found in Path, the one with the highest precedence if there were several.
+	LinkFile string
+	// Profiles are the profiles applied along with the DefaultProfile.
+	Profiles []string
+	// Root is prepended to every destination if set, so links can be tried out in a sandbox.
+	Root string
+	// Data is the contents of a JSON links file read before the LinkFiles, such as one piped to stdin.
+	Data []byte
+	// AllowedEnv are the only environment variables expanded in the paths if not nil. Links is an error if the paths use others.
+	AllowedEnv []string
+	// ExpandDirs makes every entry with a directory source recursive, instead of only those setting recursive.
+	ExpandDirs bool
+	// MergeDirs makes the entries with a directory source recursive if their destination is an existing directory, setting Merged on their links.
+	MergeDirs bool
+	// Home is the directory ~ and $HOME are resolved to in the paths. $HOME is used if empty.
+	Home string
+	// Mirror makes Links link each file under Path to the same path under Home instead of reading the links files.
+	Mirror bool
+	// Logger receives the diagnostics of Links. Nothing is logged if nil.
+	Logger *slog.Logger
+}
+
+// DefaultProfile is the profile of the entries outside of a links file's "profiles" object. It is always applied.
+const DefaultProfile = "default"
+
+// decode parses the Data and the LinkFiles, in that order.
+func (d DotDir) decode() ([]*linksFile, error) {
+	var files []*linksFile
+	if d.Data != nil {
+		f, err := decodeLinks(bytes.NewReader(d.Data), "-", d.logger())
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	for _, linkFile := range d.LinkFiles {
+		f, err := decodeLinkFile(linkFile, d.logger())
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// Links parses a list of links from the links files. Sources in later links files override the same sources in earlier ones, and sources in later Profiles override the same sources in earlier ones of the same file. A source glob pattern produces a link for each match, into the destination directory. A destination ending in a slash, or an existing directory when the source is a file, gets the source's name appended. A recursive entry produces a link for each file under the source directory. Destinations containing "{{" are rendered as a text/template with the templateData. The found links will be cleaned and returned sorted by source. An error wrapping the cause will be returned if reading a links file fails. Only warnings and debugging details are logged.
+func (d DotDir) Links() ([]Link, error) {
+	links, _, err := d.Read()
+	return links, err
+}
+
+// Read is Links, also returning the hooks of the links files. They map each phase to its commands, in the order of the links files.
+func (d DotDir) Read() ([]Link, map[string][]string, error) {
+	if d.Mirror {
+		links, err := d.mirror()
+		return links, nil, err
+	}
+	files, err := d.decode()
+	if err != nil {
+		return nil, nil, err
+	}
+	hooks := map[string][]string{}
+	for _, f := range files {
+		for phase, commands := range f.Hooks {
+			hooks[phase] = append(hooks[phase], commands...)
+		}
+	}
+	links, err := d.links(files)
+	return links, hooks, err
+}
+
+// links returns the links of the decoded links files, as described by Links.
+func (d DotDir) links(files []*linksFile) (links []Link, err error) {
+	m := map[string]linkEntry{}
+	for _, f := range files {
+		for _, profile := range append([]string{DefaultProfile}, d.Profiles...) {
+			for src, entry := range f.Profiles[profile] {
+				m[src] = entry
+			}
+		}
+	}
+	// Sort the sources so the links are always returned in the same order.
+	var srcs []string
+	for src := range m {
+		srcs = append(srcs, src)
+	}
+	sort.Strings(srcs)
+	var data *templateData
+	for _, src := range srcs {
+		entry := m[src]
+		name := src
+		if normalized, ok := normalizeSeparators(src); ok {
+			d.logger().Warn("The source uses backslashes, use / instead", "source", src, "dir", d.Path)
+			name = normalized
+		}
+		if normalized, ok := normalizeSeparators(entry.Dest); ok {
+			d.logger().Warn("The destination uses backslashes, use / instead", "dest", entry.Dest, "source", src)
+			entry.Dest = normalized
+		}
+		if strings.Contains(entry.Dest, "{{") {
+			if data == nil {
+				data, err = newTemplateData(d.Home)
+				if err != nil {
+					return nil, err
+				}
+			}
+			entry.Dest, err = renderDest(src, entry.Dest, data)
+			if err != nil {
+				return nil, fmt.Errorf("rendering the destination of %v in %v: %w", src, d.Path, err)
+			}
+		}
+		paths := []string{filepath.Join(d.Path, name)}
+		// A source starting with the DestSource token is relative to the directory of the destination, such as a sibling of it, rather than the links file.
+		rest, relative := strings.CutPrefix(name, DestSource+"/")
+		if relative {
+			paths = []string{filepath.Join(filepath.Dir(entry.Dest), rest)}
+		} else if filepath.IsAbs(name) {
+			paths = []string{filepath.Clean(name)}
+		}
+		glob := !relative && isGlob(name)
+		if glob {
+			// Link each match into the destination directory.
+			paths, err = filepath.Glob(paths[0])
+			if err != nil {
+				return nil, fmt.Errorf("matching %v in %v: %w", src, d.Path, err)
+			}
+			if len(paths) == 0 {
+				d.logger().Debug("No matches", "source", src, "dir", d.Path)
+			}
+		}
+		for _, path := range paths {
+			link := Link{
+				Src:      path,
+				Dest:     entry.Dest,
+				Force:    entry.Force,
+				MkdirAll: entry.Mkdir,
+				Copy:     entry.Copy,
+				Requires: entry.Requires,
+				Owner:    entry.Owner,
+				Order:    entry.Order,
+				Logger:   d.Logger,
+			}
+			if entry.Mode != "" {
+				mode, err := strconv.ParseUint(entry.Mode, 8, 32)
+				if err != nil || mode > 0777 {
+					return nil, fmt.Errorf("the mode %q of %v in %v is not an octal permission such as 0644", entry.Mode, src, d.Path)
+				}
+				link.Mode = os.FileMode(mode)
+			}
+			if glob {
+				link.Dest = filepath.Join(entry.Dest, filepath.Base(path))
+			}
+			// Cleaning drops the trailing slash marking a destination directory.
+			into := !glob && strings.HasSuffix(link.Dest, "/")
+			err = link.clean(d.Home, d.AllowedEnv)
+			if err != nil {
+				return nil, err
+			}
+			link.reroot(d.Root)
+			if relative && d.Root != "" {
+				link.Src = filepath.Join(d.Root, strings.TrimPrefix(link.Src, filepath.VolumeName(link.Src)))
+			}
+			if !into && !glob {
+				into = intoDir(link.Src, link.Dest)
+			}
+			if into {
+				link.Dest = filepath.Join(link.Dest, filepath.Base(link.Src))
+			}
+			recursive, merge := entry.Recursive, false
+			if (d.ExpandDirs || d.MergeDirs) && !recursive {
+				if info, err := os.Stat(link.Src); err == nil && info.IsDir() {
+					// Link the files into a real directory in the way instead of conflicting with it.
+					dest, err := os.Lstat(link.Dest)
+					merge = d.MergeDirs && err == nil && dest.IsDir()
+					recursive = d.ExpandDirs || merge
+				}
+			}
+			if !recursive {
+				links = append(links, link)
+				continue
+			}
+			tree, err := link.tree()
+			if err != nil {
+				return nil, err
+			}
+			for n := range tree {
+				tree[n].Merged = merge
+			}
+			links = append(links, tree...)
+		}
+	}
+	return
+}
+
+// mirror returns a link for each regular file under Path to the same path relative to Home, sorted by source. Git directories, the IgnoreFile and the directories and files matching its patterns are skipped.
+func (d DotDir) mirror() ([]Link, error) {
+	home := d.Home
+	if home == "" {
+		home = os.Getenv("HOME")
+	}
+	patterns, err := readIgnore(d.Path)
+	if err != nil {
+		return nil, err
+	}
+	var links []Link
+	err = filepath.Walk(d.Path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == d.Path {
+			return nil
+		}
+		rel, err := filepath.Rel(d.Path, path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == ".git" || ignored(patterns, rel) {
+			d.logger().Debug("Skipping ignored path", "path", path)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() || rel == IgnoreFile {
+			return nil
+		}
+		link := Link{Src: path, Dest: filepath.Join(home, rel), Logger: d.Logger}
+		link.reroot(d.Root)
+		links = append(links, link)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("mirroring %v: %w", d.Path, err)
+	}
+	return links, nil
+}
+
+// intoDir reports whether src is not a directory but dest is an existing one, rather than a symlink to one. Such a source is linked inside dest like ln does, while a directory source replaces dest if ForceDir is set.
+func intoDir(src, dest string) bool {
+	info, err := os.Lstat(dest)
+	if err != nil || !info.IsDir() {
+		return false
+	}
+	info, err = os.Stat(src)
+	return err == nil && !info.IsDir()
+}
+
+// templateData holds the values available to destination templates, e.g. "{{.Hostname}}/config".
+type templateData struct {
+	Hostname string
+	OS       string
+	Arch     string
+	Home     string
+	Env      map[string]string
+}
+
+func newTemplateData(home string) (*templateData, error) {
+	if home == "" {
+		home = os.Getenv("HOME")
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	data := &templateData{
+		Hostname: hostname,
+		OS:       runtime.GOOS,
+		Arch:     runtime.GOARCH,
+		Home:     home,
+		Env:      map[string]string{},
+	}
+	for _, kv := range os.Environ() {
+		if n := strings.Index(kv, "="); n > 0 {
+			data.Env[kv[:n]] = kv[n+1:]
+		}
+	}
+	return data, nil
+}
+
+// renderDest executes the destination template of the source. Unknown environment variables are an error.
+func renderDest(src, dest string, data *templateData) (string, error) {
+	t, err := template.New(src).Option("missingkey=error").Parse(dest)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	err = t.Execute(&b, data)
+	if err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// tree returns a link for each file under Src, mirroring the directory structure under Dest. The links create their missing parent directories.
+func (l Link) tree() ([]Link, error) {
+	var links []Link
+	err := filepath.Walk(l.Src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(l.Src, path)
+		if err != nil {
+			return err
+		}
+		link := l
+		link.Src = path
+		link.Dest = filepath.Join(l.Dest, rel)
+		link.MkdirAll = true
+		links = append(links, link)
+		return nil
+	})
+	return links, err
+}
+
+// isGlob reports whether the links file source is a glob pattern.
+func isGlob(src string) bool {
+	return strings.ContainsAny(src, "*?[")
+}
+
+// linkEntry is a value in a links file. It is either a destination string or an object holding the destination and per link options.
+type linkEntry struct {
+	Dest  string `json:"dest"`
+	Force bool   `json:"force"`
+	Mkdir bool   `json:"mkdir"`
+	Copy  bool   `json:"copy"`
+	// Recursive links each file under the source directory instead of the directory itself.
+	Recursive bool `json:"recursive"`
+	// Requires are the commands that must be found in $PATH for the link to be created.
+	Requires []string `json:"requires"`
+	// Owner and Mode, an octal string such as "0600", are applied to the copy of the source.
+	Owner string `json:"owner"`
+	Mode  string `json:"mode"`
+	// Order is when the link is applied relative to the others, lower first.
+	Order int `json:"order"`
+}
+
+// UnmarshalJSON decodes either the string or object form of the entry.
+func (e *linkEntry) UnmarshalJSON(data []byte) error {
+	var dest string
+	if err := json.Unmarshal(data, &dest); err == nil {
+		*e = linkEntry{Dest: dest}
+		return nil
 	}
-	return fmt.Sprintf("ln -s %v %v", l.Src, l.Dest)
+	// Use a type without the UnmarshalJSON method to decode the object form.
+	type entry linkEntry
+	return json.Unmarshal(data, (*entry)(e))
 }
 
-// Clean replaces the environment variables in the source and destination paths with the values.
-func (l *Link) Clean() {
-	l.Src = cleanPath(l.Src)
-	l.Dest = cleanPath(l.Dest)
+// linksFile is the decoded contents of a links file.
+type linksFile struct {
+	// Profiles maps each profile to its sources and link entries.
+	Profiles map[string]map[string]linkEntry
+	// Hooks maps each phase, such as "post", to the commands run in it.
+	Hooks map[string][]string
 }
 
-func cleanPath(path string) string {
-	path = filepath.Clean(path)
-	hasLeadingSlash := strings.HasPrefix(path, "/")
-	var cleanPath []string
-	for _, e := range strings.Split(path, "/") {
-		if strings.HasPrefix(e, "$") {
-			e = os.Getenv(strings.TrimPrefix(e, "$"))
-		}
-		cleanPath = append(cleanPath, e)
-	}
-	path = filepath.Join(cleanPath...)
-	if hasLeadingSlash {
-		path = "/" + path
+// decodeLinkFile parses the links file, logging the skipped notes to logger.
+func decodeLinkFile(linkFile string, logger *slog.Logger) (*linksFile, error) {
+	f, err := os.Open(linkFile)
+	if err != nil {
+		return nil, err
 	}
-	return path
+	defer f.Close()
+	return decodeLinks(f, linkFile, logger)
 }
 
-// Symlink creates a symlink using the Src and Dest. Dest will be removed if force is set.
-func (l *Link) Symlink(force bool) error {
-	if force {
-		err := os.Remove(l.Dest)
-		if err != nil {
-			return err
+// decodeLinks parses the links file read from r. The format is YAML if the name of the links file ends in .yaml or .yml, JSON otherwise. The top level entries are in the DefaultProfile, along with any in its "profiles" object. Errors are wrapped with the name of the links file, and the skipped notes logged to logger.
+func decodeLinks(r io.Reader, linkFile string, logger *slog.Logger) (*linksFile, error) {
+	var err error
+	f := &linksFile{Profiles: map[string]map[string]linkEntry{}}
+	switch strings.ToLower(filepath.Ext(linkFile)) {
+	case ".yaml", ".yml":
+		var dests map[string]string
+		dests, err = decodeYAML(r)
+		m := map[string]linkEntry{}
+		for src, dest := range dests {
+			m[src] = linkEntry{Dest: dest}
+		}
+		f.Profiles[DefaultProfile] = m
+	case ".toml":
+		var dests map[string]string
+		dests, err = decodeTOML(r)
+		m := map[string]linkEntry{}
+		for src, dest := range dests {
+			m[src] = linkEntry{Dest: dest}
+		}
+		f.Profiles[DefaultProfile] = m
+	default:
+		f, err = decodeJSONLinks(r)
+	}
+	if err == nil {
+	check:
+		for _, m := range f.Profiles {
+			for src, entry := range m {
+				if metadataKey(src) {
+					logger.Debug("Skipping a note", "key", src, "file", linkFile)
+					delete(m, src)
+					continue
+				}
+				if entry.Dest == "" {
+					err = fmt.Errorf("missing destination for %v", src)
+					break check
+				}
+			}
 		}
 	}
-	return os.Symlink(l.Src, l.Dest)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %v: %w", linkFile, err)
+	}
+	return f, nil
 }
 
-// DotDir is a directory containing a links file. The paths in the links file, if not absolute, will be relative to the Path attribute.
-type DotDir struct {
-	Path     string
-	LinkFile string
+// metadataKey reports whether the links file key is a note such as "_comment" rather than a source, which is the case for the keys starting with an underscore.
+func metadataKey(key string) bool {
+	return strings.HasPrefix(key, "_")
 }
 
-// Links parses a list of links from the links file. The found links will be cleaned and returned. An error will be returned if parsing the links file fails.
-func (d DotDir) Links() (links []Link, err error) {
-	f, err := os.Open(d.LinkFile)
+// decodeJSONLinks decodes a JSON links file, which may have comments and trailing commas, moving the entries of the "profiles" object into their own maps and reading the commands of the "hooks" object.
+func decodeJSONLinks(r io.Reader) (*linksFile, error) {
+	data, err := io.ReadAll(r)
 	if err != nil {
-		log.Printf("Error openeing link file %v: %v", d.LinkFile, err)
 		return nil, err
 	}
-	defer f.Close()
-
-	var m map[string]string
-	err = json.NewDecoder(f).Decode(&m)
+	var raw map[string]json.RawMessage
+	err = json.Unmarshal(stripJSONC(data), &raw)
 	if err != nil {
-		log.Printf("Error parsing link file %v: %v", d.LinkFile, err)
 		return nil, err
 	}
-	for src, dest := range m {
-		src = filepath.Join(d.Path, src)
-		link := Link{Src: src, Dest: dest}
-		link.Clean()
-		links = append(links, link)
+	profiles := map[string]map[string]linkEntry{}
+	if data, ok := raw["profiles"]; ok {
+		err = json.Unmarshal(data, &profiles)
+		if err != nil {
+			return nil, fmt.Errorf("profiles: %v", err)
+		}
+		delete(raw, "profiles")
 	}
-	return
+	var hooks map[string][]string
+	if data, ok := raw["hooks"]; ok {
+		err = json.Unmarshal(data, &hooks)
+		if err != nil {
+			return nil, fmt.Errorf("hooks: %v", err)
+		}
+		delete(raw, "hooks")
+	}
+	m := profiles[DefaultProfile]
+	if m == nil {
+		m = map[string]linkEntry{}
+	}
+	for src, data := range raw {
+		// Notes can hold any value, so leave them undecoded for decodeLinks to drop.
+		if metadataKey(src) {
+			m[src] = linkEntry{}
+			continue
+		}
+		var entry linkEntry
+		err = json.Unmarshal(data, &entry)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", src, err)
+		}
+		m[src] = entry
+	}
+	profiles[DefaultProfile] = m
+	return &linksFile{Profiles: profiles, Hooks: hooks}, nil
 }
 
 // Bootstrap manages a list of files that need to be symlinked.
 type Bootstrap struct {
 	DotDirs []DotDir
+	// LinkFiles are the links file names searched for by Walk, in order of precedence. The package LinkFiles are used if empty.
+	LinkFiles []string
+	// LinkFileGlob is a filepath.Match pattern of more links file names searched for by Walk, such as "*.links.json". Every match in a directory is used, in name order, after the LinkFiles.
+	LinkFileGlob string
+	// Concurrency is the maximum number of links files read at once by Link. GOMAXPROCS is used if not positive.
+	Concurrency int
+	// Hidden makes Walk search directories starting with a dot.
+	Hidden bool
+	// FollowSymlinks makes Walk search the directories symlinks point to, each real directory once.
+	FollowSymlinks bool
+	// MaxDepth is how many levels of directories below the root Walk searches, 0 only searching the root itself. There is no limit if negative, as set by NewBootstrap.
+	MaxDepth int
+	// Hooks makes Link run the pre hooks of each DotDir read before adding its links to the chan. If one fails, its HookError is added followed by the links with ErrBlocked.
+	Hooks bool
+	// Profiles are the profiles applied by the DotDirs added, along with the DefaultProfile.
+	Profiles []string
+	// Root is the sandbox root of the DotDirs added.
+	Root string
+	// AllowedEnv are the environment variables the DotDirs added may expand.
+	AllowedEnv []string
+	// ExpandDirs makes the DotDirs added link each file in directory sources.
+	ExpandDirs bool
+	// MergeDirs makes the DotDirs added link each file in directory sources into their destination if it is an existing directory.
+	MergeDirs bool
+	// Home is the home directory of the DotDirs added.
+	Home string
+	// Timeout is how long Link, Validate and Prune wait for the links of each DotDir to be read. DefaultTimeout is used if zero, and there is no limit if negative.
+	Timeout time.Duration
+	// Warnings are the errors from paths Walk couldn't read.
+	Warnings []error
+	// HookRuns are the hooks run by LinkContext and RunPostHooks that succeeded, with their output.
+	HookRuns []HookRun
+	// hooks are the hooks read by LinkContext, by path, for the DotDirs whose pre hooks succeeded. RunPostHooks runs their post hooks.
+	hooks map[string]map[string][]string
+	// mu guards HookRuns and hooks while LinkContext reads the DotDirs.
+	mu sync.Mutex
+	// Excluded are the DotDirs found but left out of the run, such as by -only. Prune keeps the links they still list.
+	Excluded []DotDir
+	// Unsearched are the directories that were to be searched but weren't, such as a missing one. Prune keeps the links to the sources under them.
+	Unsearched []string
+	// Logger receives the diagnostics of Walk and the DotDirs added. Nothing is logged if nil.
+	Logger *slog.Logger
+}
+
+// Option configures a Bootstrap made by NewBootstrap.
+type Option func(*Bootstrap)
+
+// WithLogger logs the diagnostics of the Bootstrap to l.
+func WithLogger(l *slog.Logger) Option {
+	return func(b *Bootstrap) { b.Logger = l }
+}
+
+// NewBootstrap returns an empty Bootstrap configured by opts. Nothing is logged unless WithLogger is given.
+func NewBootstrap(opts ...Option) *Bootstrap {
+	b := &Bootstrap{Logger: discardLogger, MaxDepth: -1}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// discardLogger drops every record, for a nil Logger.
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelError + 1}))
+
+func (b *Bootstrap) logger() *slog.Logger {
+	if b.Logger == nil {
+		return discardLogger
+	}
+	return b.Logger
+}
+
+func (l Link) logger() *slog.Logger {
+	if l.Logger == nil {
+		return discardLogger
+	}
+	return l.Logger
+}
+
+func (d DotDir) logger() *slog.Logger {
+	if d.Logger == nil {
+		return discardLogger
+	}
+	return d.Logger
 }
 
-// AddDir adds a DotDir to the DotDirs given the directory path and path to the links file.
+// AddDir adds a DotDir to the DotDirs given the directory path and path to the links file. The links file is added to the existing DotDir if dir has already been added.
 func (b *Bootstrap) AddDir(dir, links string) {
+	for n := range b.DotDirs {
+		if b.DotDirs[n].Path == dir {
+			b.DotDirs[n].LinkFiles = append(b.DotDirs[n].LinkFiles, links)
+			return
+		}
+	}
 	b.DotDirs = append(b.DotDirs, DotDir{
-		Path:     dir,
-		LinkFile: links,
+		Path:       dir,
+		LinkFiles:  []string{links},
+		Profiles:   b.Profiles,
+		Root:       b.Root,
+		AllowedEnv: b.AllowedEnv,
+		ExpandDirs: b.ExpandDirs,
+		MergeDirs:  b.MergeDirs,
+		Home:       b.Home,
+		Logger:     b.Logger,
 	})
 }
 
-// Walk traverses the specified directory. Any directories found containing a links file will be added to the DotDirs attribute. An error will be returned if the walking fails.
-func (b *Bootstrap) Walk(dir string) error {
-	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-		// Check for link file
-		if info.Name() == LinkFile {
-			d, _ := filepath.Split(path)
-			b.AddDir(d, path)
-		}
-		return nil
+// AddMirror adds a DotDir linking each file under dir to the same path under the home directory, without any links files.
+func (b *Bootstrap) AddMirror(dir string) {
+	b.DotDirs = append(b.DotDirs, DotDir{
+		Path:       dir,
+		Profiles:   b.Profiles,
+		Root:       b.Root,
+		AllowedEnv: b.AllowedEnv,
+		ExpandDirs: b.ExpandDirs,
+		MergeDirs:  b.MergeDirs,
+		Home:       b.Home,
+		Mirror:     true,
+		Logger:     b.Logger,
 	})
+}
+
+// Walk traverses the specified directory. Any directories found containing a links file will be added to the DotDirs attribute, along with any local links file such as links.local.json. If a directory has more than one of the LinkFiles, only the first in their order is used. Symlinks to directories are followed if FollowSymlinks is set, searching each real directory once. Directories starting with a dot, unless Hidden is set, directories matching a pattern in the IgnoreFile at the root of dir and directories more than MaxDepth levels below dir, unless it is negative, are skipped. Paths that can't be read are added to Warnings. An error will be returned if the walking fails.
+func (b *Bootstrap) Walk(dir string) error {
+	if _, err := filepath.Match(b.LinkFileGlob, ""); err != nil {
+		return fmt.Errorf("links file glob %v: %w", b.LinkFileGlob, err)
+	}
+	patterns, err := readIgnore(dir)
+	if err != nil {
+		return err
+	}
+	// The real paths of the directories searched, so a symlink back into the tree can't make the walk loop.
+	visited := map[string]bool{}
+	var walk func(root string) error
+	walk = func(root string) error {
+		return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			// Record unreadable paths and carry on with the rest
+			if err != nil {
+				b.Warnings = append(b.Warnings, fmt.Errorf("walking %v: %w", path, err))
+				return nil
+			}
+			// Follow the symlinks to directories as if they were directories
+			link := false
+			// Skipping a symlink is done by not following it, as SkipDir would skip the rest of its directory.
+			skip := filepath.SkipDir
+			if b.FollowSymlinks && info.Mode()&os.ModeSymlink != 0 {
+				if target, err := os.Stat(path); err == nil && target.IsDir() {
+					link = true
+					info = target
+					skip = nil
+				}
+			}
+			if info.IsDir() && path != root {
+				// Skip hidden and ignored directories
+				if !b.Hidden && strings.HasPrefix(filepath.Base(path), ".") {
+					b.logger().Debug("Skipping hidden directory", "path", path)
+					return skip
+				}
+				rel, _ := filepath.Rel(dir, path)
+				if ignored(patterns, rel) {
+					b.logger().Debug("Skipping ignored directory", "path", path)
+					return skip
+				}
+				if depth := strings.Count(rel, string(filepath.Separator)) + 1; b.MaxDepth >= 0 && depth > b.MaxDepth {
+					b.logger().Debug("Skipping directory below the maximum depth", "path", path, "depth", depth)
+					return skip
+				}
+			}
+			// The root of a symlink's walk was checked at the symlink.
+			if info.IsDir() && b.FollowSymlinks && (path != root || root == dir) {
+				if real, err := filepath.EvalSymlinks(path); err == nil {
+					if visited[real] {
+						b.logger().Debug("Skipping a directory already searched", "path", path, "real", real)
+						return skip
+					}
+					visited[real] = true
+				}
+			}
+			if link {
+				// The trailing separator makes Walk search the target rather than stop at the link.
+				return walk(path + string(filepath.Separator))
+			}
+			// Check for link file
+			if b.linkFileRank(info.Name()) >= 0 {
+				b.logger().Debug("Found link file", "path", path)
+				d, _ := filepath.Split(path)
+				b.AddDir(d, path)
+			}
+			return nil
+		})
+	}
+	err = walk(dir)
 	if err != nil && err != io.EOF {
 		return err
 	}
+	// Only use the links file with the highest precedence in each directory, with its local links file overriding it, along with every match of the LinkFileGlob.
+	for n := range b.DotDirs {
+		dotDir := &b.DotDirs[n]
+		if len(dotDir.LinkFiles) == 0 {
+			continue
+		}
+		sort.SliceStable(dotDir.LinkFiles, func(m, n int) bool {
+			return b.linkFileRank(filepath.Base(dotDir.LinkFiles[m])) < b.linkFileRank(filepath.Base(dotDir.LinkFiles[n]))
+		})
+		dotDir.LinkFile = filepath.Base(dotDir.LinkFiles[0])
+		var linkFiles []string
+		for _, linkFile := range dotDir.LinkFiles {
+			if name := filepath.Base(linkFile); name != dotDir.LinkFile && name != localLinkFile(dotDir.LinkFile) && !b.globLinkFile(name) {
+				b.logger().Info("Ignoring a links file of lower precedence", "path", linkFile, "precedence", dotDir.LinkFile)
+				continue
+			}
+			linkFiles = append(linkFiles, linkFile)
+		}
+		dotDir.LinkFiles = linkFiles
+	}
+	return nil
+}
+
+// ReadDirs adds the DotDirs listed in the file at path instead of walking a directory, in the order they are listed. Each line holds the path of a directory, optionally followed by a colon and the name of its links file, such as "zsh:links.json". Without a name, the first of the LinkFiles found in the directory is used. Relative paths are relative to the directory of the file. Blank lines and lines starting with # are skipped.
+func (b *Bootstrap) ReadDirs(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	base, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	linkFiles := b.LinkFiles
+	if len(linkFiles) == 0 {
+		linkFiles = LinkFiles
+	}
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		dir, linkFile := line, ""
+		// A colon followed by a path is part of the directory, such as the volume of C:\dotfiles.
+		if i := strings.LastIndex(line, ":"); i >= 0 && !strings.ContainsAny(line[i+1:], `/\`) {
+			dir, linkFile = line[:i], line[i+1:]
+		}
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(base, dir)
+		}
+		dir = filepath.Clean(dir) + string(filepath.Separator)
+		if linkFile == "" {
+			for _, name := range linkFiles {
+				if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+					linkFile = name
+					break
+				}
+			}
+			if linkFile == "" {
+				return fmt.Errorf("%v:%v: no links file in %v", path, n+1, dir)
+			}
+		}
+		b.AddDir(dir, filepath.Join(dir, linkFile))
+	}
 	return nil
 }
 
-// Link adds the links from each of the DotDirs to the links chan. If an error occurs while getting a DotDirs links, the error will be added to the errors chan.
-func (b *Bootstrap) Link(links chan Link, errors chan error) {
-	toLinks := func(l Link) {
-		if links != nil {
-			links <- l
+// IgnoreFile is the name of the file at the root of the dotfiles directory listing gitignore style glob patterns of directories Walk should skip.
+const IgnoreFile = ".bootstrapignore"
+
+// readIgnore reads the patterns from the IgnoreFile in dir. Blank lines, comments and negated patterns are skipped. A missing IgnoreFile is not an error.
+func readIgnore(dir string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, IgnoreFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var patterns []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") {
+			continue
 		}
+		patterns = append(patterns, line)
 	}
-	toErrors := func(e error) {
-		if errors != nil {
-			errors <- e
+	return patterns, nil
+}
+
+// ignored reports whether the directory at rel, relative to the walk root, matches any of the patterns. Patterns containing a slash are matched against the whole relative path, others against the directory name.
+func ignored(patterns []string, rel string) bool {
+	rel = filepath.ToSlash(rel)
+	for _, pattern := range patterns {
+		pattern = strings.TrimSuffix(pattern, "/")
+		name := path.Base(rel)
+		if strings.Contains(pattern, "/") {
+			pattern = strings.TrimPrefix(pattern, "/")
+			name = rel
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
 		}
 	}
-	wg := &sync.WaitGroup{}
+	return false
+}
+
+// Validate reads the links from all the DotDirs without creating any of them. Errors are returned for links files that can't be read, sources that don't exist, destinations linked to by more than one source and destinations that are, or would contain, their own source.
+func (b *Bootstrap) Validate() []error {
+	_, errs := b.validate()
+	return errs
+}
+
+// validate is Validate, also returning the number of links read.
+func (b *Bootstrap) validate() (int, []error) {
+	var errs []error
+	count := 0
+	srcs := map[string]string{}
 	for _, dotDir := range b.DotDirs {
+		links, err := b.readLinks(dotDir)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		count += len(links)
+		for _, link := range links {
+			if _, err := os.Lstat(link.Src); err != nil {
+				errs = append(errs, fmt.Errorf("source %v does not exist: %v", link.Src, link))
+			}
+			if err := link.selfLink(); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if src, ok := srcs[link.Dest]; ok && src != link.Src {
+				errs = append(errs, fmt.Errorf("%v and %v both link to %v", src, link.Src, link.Dest))
+				continue
+			}
+			srcs[link.Dest] = link.Src
+		}
+	}
+	return count, errs
+}
+
+// selfLink returns an error if Dest is Src or one of its parent directories, in which case linking would replace the source with a link to itself.
+func (l Link) selfLink() error {
+	src, dest := filepath.Clean(l.Src), filepath.Clean(l.Dest)
+	if src == dest {
+		return fmt.Errorf("%v links to itself", src)
+	}
+	if inside(src, dest) {
+		return fmt.Errorf("%v is inside its destination %v", src, dest)
+	}
+	return nil
+}
+
+// inside reports whether path is dir or under it.
+func inside(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Prune removes the symlinks recorded in s whose destinations are no longer in any of the DotDirs or Excluded links files, unless their source is under one of the Unsearched directories. Only symlinks still pointing at the recorded source are removed, the others are returned with ErrNotLinked. Both are removed from s. Nothing is changed unless apply is set, or if a links file can't be read.
+func (b *Bootstrap) Prune(s *State, apply bool) ([]LinkResult, error) {
+	current := map[string]bool{}
+	for _, dotDir := range append(b.DotDirs[:len(b.DotDirs):len(b.DotDirs)], b.Excluded...) {
+		links, err := b.readLinks(dotDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, link := range links {
+			current[link.Dest] = true
+		}
+	}
+	var dests []string
+	for dest, src := range s.Links {
+		if current[dest] {
+			continue
+		}
+		// The links files of a directory left unsearched may still list it.
+		unsearched := false
+		for _, dir := range b.Unsearched {
+			unsearched = unsearched || inside(src, dir)
+		}
+		if !unsearched {
+			dests = append(dests, dest)
+		}
+	}
+	sort.Strings(dests)
+	var results []LinkResult
+	for _, dest := range dests {
+		link := Link{Src: s.Links[dest], Dest: dest}
+		err := link.Unlink(apply)
+		if apply && (err == nil || err == ErrNotLinked) {
+			s.forget(dest)
+		}
+		results = append(results, LinkResult{Link: link, Err: err})
+	}
+	return results, nil
+}
+
+// Link adds a LinkResult for each of the links from the DotDirs to the results chan. At most Concurrency links files are read at once. If an error occurs while getting a DotDirs links, a LinkResult holding only the error will be added to the results chan.
+func (b *Bootstrap) Link(results chan LinkResult) {
+	b.LinkContext(context.Background(), results)
+}
+
+// LinksSlice is Link, but returns the links and errors once all of them are read instead of adding them to a chan. The links are in the order Link would add them. The links blocked by a failing pre hook are returned as errors wrapping ErrBlocked.
+func (b *Bootstrap) LinksSlice() ([]Link, []error) {
+	var links []Link
+	var errs []error
+	results := make(chan LinkResult)
+	go func() {
+		b.Link(results)
+		close(results)
+	}()
+	for result := range results {
+		switch {
+		case result.Err == ErrBlocked:
+			errs = append(errs, fmt.Errorf("%v: %w", result.Link, result.Err))
+		case result.Err != nil:
+			errs = append(errs, result.Err)
+		default:
+			links = append(links, result.Link)
+		}
+	}
+	return links, errs
+}
+
+// ErrBlocked is the error of the links not to be created because a pre hook of their DotDir failed.
+var ErrBlocked = errors.New("blocked by a failing pre hook")
+
+// ErrTimeout is wrapped by the error returned when reading the links of a DotDir takes longer than the Bootstrap Timeout.
+var ErrTimeout = errors.New("timed out")
+
+// DefaultTimeout is the Timeout used by a Bootstrap without one.
+const DefaultTimeout = 30 * time.Second
+
+// readLinks returns the Links of dotDir, or an error wrapping ErrTimeout if they take longer than the Timeout to read. The read is abandoned rather than stopped, as a stalled file system can't be interrupted.
+func (b *Bootstrap) readLinks(dotDir DotDir) ([]Link, error) {
+	links, _, err := b.read(dotDir)
+	return links, err
+}
+
+// read is readLinks, also returning the hooks of dotDir from the same read.
+func (b *Bootstrap) read(dotDir DotDir) ([]Link, map[string][]string, error) {
+	timeout := b.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	if timeout < 0 {
+		return dotDir.Read()
+	}
+	type read struct {
+		links []Link
+		hooks map[string][]string
+		err   error
+	}
+	// Buffer the result so the goroutine can finish after being abandoned.
+	done := make(chan read, 1)
+	go func() {
+		links, hooks, err := dotDir.Read()
+		done <- read{links, hooks, err}
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.links, r.hooks, r.err
+	case <-timer.C:
+		return nil, nil, fmt.Errorf("reading links in %v: %w after %v", dotDir.Path, ErrTimeout, timeout)
+	}
+}
+
+// LinkContext is Link, but stops reading links files and adding to the chan once ctx is done. Links files already being read are allowed to finish.
+func (b *Bootstrap) LinkContext(ctx context.Context, results chan LinkResult) {
+	toResults := func(r LinkResult) bool {
+		if results == nil {
+			return true
+		}
+		select {
+		case results <- r:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	concurrency := b.Concurrency
+	if concurrency <= 0 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+	sem := make(chan struct{}, concurrency)
+	wg := &sync.WaitGroup{}
+	for n, dotDir := range b.DotDirs {
+		// Wait for a free slot before spawning so that a concurrency of 1 reads the DotDirs in order.
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
 		wg.Add(1)
-		go func(dotDir DotDir) {
+		go func(n int, dotDir DotDir) {
 			defer wg.Done()
-			links, err := dotDir.Links()
+			defer func() { <-sem }()
+			links, hooks, err := b.read(dotDir)
 			if err != nil {
-				toErrors(err)
+				// The hooks of a links file that can't be read are left unrun along with its links.
+				toResults(LinkResult{Err: err, dotDir: n})
+				return
+			}
+			result := LinkResult{dotDir: n}
+			if b.Hooks {
+				ran, err := dotDir.RunHooks("pre", hooks)
+				b.mu.Lock()
+				b.HookRuns = append(b.HookRuns, ran...)
+				if err == nil {
+					if b.hooks == nil {
+						b.hooks = map[string]map[string][]string{}
+					}
+					b.hooks[dotDir.Path] = hooks
+				}
+				b.mu.Unlock()
+				if err != nil {
+					toResults(LinkResult{Err: err, dotDir: n})
+					result.Err = ErrBlocked
+				}
 			}
 			for _, link := range links {
-				toLinks(link)
+				result.Link = link
+				if !toResults(result) {
+					return
+				}
 			}
-		}(dotDir)
+		}(n, dotDir)
 	}
 	wg.Wait()
 }
 
+// RunPostHooks runs the post hooks of the DotDirs, in order, once LinkContext has added their links. Those whose links couldn't be read or whose pre hooks failed are skipped. A HookError is returned for each DotDir with a failing hook.
+func (b *Bootstrap) RunPostHooks() []error {
+	var errs []error
+	for _, dotDir := range b.DotDirs {
+		b.mu.Lock()
+		hooks, ok := b.hooks[dotDir.Path]
+		b.mu.Unlock()
+		if !ok {
+			continue
+		}
+		ran, err := dotDir.RunHooks("post", hooks)
+		b.mu.Lock()
+		b.HookRuns = append(b.HookRuns, ran...)
+		b.mu.Unlock()
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// Log levels for logHandler. Higher levels are only logged with more verbosity.
+const (
+	LevelError = iota
+	LevelInfo
+	LevelDebug
+)
+
+// Verbosity is the highest level logged by logHandler. Negative values silence all logging.
+var Verbosity = LevelError
+
+// logHandler is a slog.Handler writing records to the log package, as the message followed by key=value attributes. Warnings and errors are logged at LevelError, info at LevelInfo and the rest at LevelDebug.
+type logHandler struct {
+	attrs string
+	group string
+}
+
+func (h logHandler) Enabled(_ context.Context, level slog.Level) bool {
+	switch {
+	case level >= slog.LevelWarn:
+		return LevelError <= Verbosity
+	case level >= slog.LevelInfo:
+		return LevelInfo <= Verbosity
+	}
+	return LevelDebug <= Verbosity
+}
+
+func (h logHandler) Handle(_ context.Context, r slog.Record) error {
+	msg := r.Message
+	switch {
+	case r.Level >= slog.LevelError:
+		msg = "Error: " + msg
+	case r.Level >= slog.LevelWarn:
+		msg = "Warning: " + msg
+	}
+	attrs := h.attrs
+	r.Attrs(func(a slog.Attr) bool {
+		attrs += h.format(a)
+		return true
+	})
+	log.Print(msg + attrs)
+	return nil
+}
+
+func (h logHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	for _, a := range attrs {
+		h.attrs += h.format(a)
+	}
+	return h
+}
+
+func (h logHandler) WithGroup(name string) slog.Handler {
+	h.group += name + "."
+	return h
+}
+
+func (h logHandler) format(a slog.Attr) string {
+	return fmt.Sprintf(" %v%v=%v", h.group, a.Key, a.Value)
+}
+
+// verbosity is a flag.Value counting the number of times it is set, e.g. -v -v.
+type verbosity struct{ n *int }
+
+func (v verbosity) String() string {
+	if v.n == nil {
+		return "0"
+	}
+	return strconv.Itoa(*v.n)
+}
+
+func (v verbosity) Set(s string) error {
+	if s == "true" {
+		*v.n++
+		return nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	*v.n = n
+	return nil
+}
+
+func (v verbosity) IsBoolFlag() bool { return true }
+
+// globs is a flag.Value collecting a value each time it is set, e.g. -only vim -only zsh.
+type globs struct{ patterns *[]string }
+
+func (g globs) String() string {
+	if g.patterns == nil {
+		return ""
+	}
+	return strings.Join(*g.patterns, ",")
+}
+
+func (g globs) Set(s string) error {
+	*g.patterns = append(*g.patterns, s)
+	return nil
+}
+
 // DotEnv is the name of the environment variable signifying the location of the dotfiles needing bootstrapping.
 const DotEnv = "DOT"
 
-// LinkFile is the name the file describing symlinks relative to the current directory.
-const LinkFile = "links.json"
+// LinkFiles are the names of the files describing symlinks relative to the current directory, in order of precedence.
+var LinkFiles = []string{"links.json", "links.yaml", "links.yml", "links.toml"}
 
-func main() {
-	i := Input{
-		Dir:   os.Getenv(DotEnv),
-		Dry:   false,
-		Force: false,
+// localLinkFile returns the name of the local links file overriding name, e.g. links.local.json for links.json.
+func localLinkFile(name string) string {
+	ext := filepath.Ext(name)
+	return strings.TrimSuffix(name, ext) + ".local" + ext
+}
+
+// linkFileRank returns the precedence of the links file name, or -1 if name is not a links file. The LinkFiles rank in order, followed by the matches of the LinkFileGlob and then the local links files.
+func (b *Bootstrap) linkFileRank(name string) int {
+	linkFiles := b.LinkFiles
+	if len(linkFiles) == 0 {
+		linkFiles = LinkFiles
+	}
+	for n, linkFile := range linkFiles {
+		if name == linkFile {
+			return n
+		}
+	}
+	if b.globLinkFile(name) {
+		return len(linkFiles)
+	}
+	for n, linkFile := range linkFiles {
+		if name == localLinkFile(linkFile) {
+			return len(linkFiles) + 1 + n
+		}
+	}
+	return -1
+}
+
+// globLinkFile reports whether name matches the LinkFileGlob.
+func (b *Bootstrap) globLinkFile(name string) bool {
+	if b.LinkFileGlob == "" {
+		return false
 	}
+	ok, _ := filepath.Match(b.LinkFileGlob, name)
+	return ok
+}
+
+func main() {
 	flag.Usage = func() {
 		fmt.Print(`Bootstrap : github.com/dangerhuss/bootstrap
 
 Blah blah blah blah bootstrap blah blah blah.
 
 Options:
--d -dir    The dotfiles source directory that needs bootstrapping.
--n -dry    Print out the ln commands instead of creating the links.
--f -force  Overwrite existing links.
+-d -dir      The dotfiles source directories that need bootstrapping, separated by commas.
+             Use - to read a single links file from stdin, relative to the working directory.
+-n -dry      Print out the current and desired destinations instead of creating the links, grouped
+             into the New, Replace, Skip and Conflict links.
+-f -force    Overwrite existing links.
+-b -backup   Move existing files aside instead of removing them when forcing.
+-backup-dir  Move the backups made by -backup into this directory, under their full paths.
+-force-dir   Overwrite existing directories, along with everything in them.
+-s -status   Report the state of each link without changing anything.
+-p -mkdir    Create missing parent directories of the destinations.
+-l -linkfile The links file name to search for instead of links.json, links.yaml, links.yml or links.toml.
+             May be repeated, a directory with several of the names uses the first one given.
+-link-file-glob Also use every links file matching the pattern, such as *.links.json.
+-u -unlink   Remove the links instead of creating them.
+-r -relative Create symlinks relative to the destination directory.
+-json        Print the results as a JSON object.
+-v           Log more details, repeat for even more.
+-q -quiet    Only print errors.
+-check-source Don't create links to sources that don't exist.
+-c -copy     Copy the sources instead of linking them.
+-prune       Remove links created by a previous run that are no longer in a links file. The links files
+             left out by -only or -exclude and the sources of missing directories still count.
+-state       The file recording the created links and when, for -prune, -since and -sync. Defaults to
+             $HOME/.bootstrap-state.json, only written by the runs using it or setting -state.
+-manifest    Same as -state.
+-a -all      Also search directories starting with a dot, such as .git.
+-no-color    Don't color the output, even on a terminal.
+-only        Only bootstrap the directories matching the pattern, may be repeated.
+-exclude     Don't bootstrap the directories matching the pattern, even if they match -only.
+-profile     Also link the entries of the named profile in the links files, may be repeated.
+-timeout     How long to wait for the links files of a directory to be read. Defaults to 30s.
+-root        Link everything under this directory instead of /, to try out the links in a sandbox. The
+             default -state is kept under it too.
+-i           Ask before overwriting each existing file, forcing without a terminal only if -force is set.
+-since       Skip the links whose source hasn't been modified since the last run.
+-allow-env   Only expand this environment variable, and the others allowed, in the links files.
+-dir-links   Link directory sources as a whole, the default. Use -dir-links=false to link each file in
+             them instead, as entries setting recursive always do.
+-doctor      Check the setup for common problems, such as missing sources, and suggest fixes.
+-home        Resolve ~ and $HOME in the links files, the default -state and the config file to this directory.
+-retries     How many more times to try creating a link that failed with a transient error, such as
+             EIO on a network mount. Defaults to 0.
+-retry-delay How long to wait before the first retry, doubled for each of the next. Defaults to 100ms.
+-mirror      Link every file under the source directories to the same path under the home directory,
+             without any links files. Paths matching the .bootstrapignore patterns are skipped.
+-hardlink    With -copy, hard link the sources on the same filesystem as their destination instead.
+             Links with a mode or owner are still copied, leaving the source as it is.
+-allow-missing Skip the source directories that don't exist instead of failing.
+-progress    Show a running count of the links handled on stderr, if it is a terminal and -json isn't set.
+-trailer     The reminder printed on stderr after making changes, if it is a terminal. Use -trailer= to
+             never print it.
+-overwrite-symlinks-only Replace the destinations that are symlinks, but never real files or directories.
+-env-file    Set the KEY=VALUE environment variables in the file before expanding the paths, may be
+             repeated with the later files overriding the earlier ones.
+-dirs-from   Bootstrap the directories listed in the file, one "path[:linkfile]" per line, in order
+             instead of searching -dir.
+-follow      Also search the directories linked to by symlinks in the dotfiles directories, each only once.
+-max-depth   Don't search for links files more than this many directories below the dotfiles directories.
+             0 only searches the directories themselves, negative values don't limit the depth.
+-parallel-dirs How many links files to read at once. Defaults to the number of CPUs.
+-parallel-links How many links to create at once. Defaults to 1.
+-sync        Make the links match the links files exactly: create the missing links, fix the links made by
+             a previous run that point elsewhere and prune the ones no longer in a links file.
+-merge-dirs  Link each file of a directory source into its destination if that is an existing directory,
+             instead of replacing it. Files already in it with the same contents are skipped.
+-verify      Check every link is in place once they are all created, failing if any isn't.
+-list        Print the directories found and their links files instead of linking.
+-print-config Print the options after applying the config file, $DOT and the flags, then exit.
+
+Configuration:
+Options can also be set as "option: value" pairs, e.g. "force: true", in a
+bootstrap.yaml or .bootstraprc file in the working or home directory. Flags
+override the $DOT environment variable, which overrides the file, which
+overrides the defaults.
 
 Source: github.com/dangerhuss/bootstrap/issues
 License: MIT License Copyright (c) 2017 Andrew Huss
 
 `)
 	}
-	flag.StringVar(&i.Dir, "dir", i.Dir, "")
-	flag.StringVar(&i.Dir, "d", i.Dir, "")
-
-	flag.BoolVar(&i.Dry, "dry", i.Dry, "")
-	flag.BoolVar(&i.Dry, "n", i.Dry, "")
-
-	flag.BoolVar(&i.Force, "force", i.Force, "")
-	flag.BoolVar(&i.Force, "f", i.Force, "")
-	flag.Parse()
-
-	dir, err := filepath.Abs(i.Dir)
+	i, err := LoadConfig(flag.CommandLine, os.Args[1:])
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// Create and populate the Bootstrap DotDirs
-	b := &Bootstrap{}
-	err = b.Walk(dir)
+	Verbosity = LevelError + i.Verbose
+
+	summary, err := Run(i, os.Stdout)
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	// Create the needed chans
-	links := make(chan Link)
-	errors := make(chan error)
-
-	wg := new(sync.WaitGroup)
-	wg.Add(1) // Add 1 for the single go routine listening on the above chans
-	messages := map[string][]string{}
-
-	// Spawn a go routine to create the desired links
-	go func(messages map[string][]string) {
-		defer wg.Done()
-		var linksDone, errorsDone bool
-		for !linksDone || !errorsDone {
-			select {
-			case link, ok := <-links:
-				if !ok {
-					// The links chan has been closed.
-					linksDone = true
-					continue
-				}
-
-				if i.Dry {
-					// Add the ln commands to the messages map.
-					a := messages["Commands"]
-					messages["Commands"] = append(a, link.cmd(i.Force))
-					continue
-				}
-
-				// Write the symlink. Use the user specified force flag.
-				err := link.Symlink(i.Force)
-				if err != nil {
-					if lerr, ok := err.(*os.LinkError); ok {
-						// Grab the err causing the LinkError
-						err = lerr.Err
-					}
-					// Add the Symlink error to the messages map.
-					a := messages["Failures"]
-					messages["Failures"] = append(a, fmt.Sprintf("%v: %v", err, link))
-					continue
-				}
-				// Add the newly created Link string to the messages map.
-				a := messages["Successes"]
-				messages["Successes"] = append(a, link.String())
-			case err, ok := <-errors:
-				if !ok {
-					// The errors chan has been closed
-					errorsDone = true
-					continue
-				}
-				// Add the bootstrap error to the messages map.
-				a := messages["Errors"]
-				messages["Errors"] = append(a, err.Error())
-			}
-		}
-	}(messages)
-
-	// Kick off the links method.
-	b.Link(links, errors)
-
-	// Links only returns once all the links or errors
-	// have been added to the respective chan.We can
-	// safley close the links and errors chans.
-	close(links)
-	close(errors)
-	// Wait for all the symlinks to be created.
-	wg.Wait()
-	// Print out all the messages
-	for header, msgs := range messages {
-		if len(messages) > 1 {
-			fmt.Println(header + ":")
-		}
-		fmt.Println(strings.Join(msgs, "\n"))
-	}
-	if len(messages) > 0 {
-		fmt.Println("Changes will take effect after sourcing your .*shrc")
+	if !i.Dry && !i.Status && !i.Quiet && !i.JSON && !i.List && !i.Doctor && !i.PrintConfig {
+		fmt.Fprintln(os.Stderr, summary)
 	}
 }
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+// captureTerminal makes os.Stderr a pseudo terminal until the end of the test, returning a function that stops the capture and returns what was written to it.
+func captureTerminal(t *testing.T) func() string {
+	t.Helper()
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if err != nil {
+		t.Skip(err)
+	}
+	var unlock, n int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+		master.Close()
+		t.Skip(errno)
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); errno != 0 {
+		master.Close()
+		t.Skip(errno)
+	}
+	slave, err := os.OpenFile(fmt.Sprintf("/dev/pts/%v", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		t.Skip(err)
+	}
+	var buf bytes.Buffer
+	read := make(chan struct{})
+	go func() {
+		// Reading fails once the slave is closed.
+		io.Copy(&buf, master)
+		close(read)
+	}()
+	stderr := os.Stderr
+	os.Stderr = slave
+	stop := func() string {
+		if os.Stderr == slave {
+			os.Stderr = stderr
+			slave.Close()
+			<-read
+			master.Close()
+		}
+		return buf.String()
+	}
+	t.Cleanup(func() { stop() })
+	return stop
+}
+
+func TestRunTrailer(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(i *Input)
+		want  string
+	}{
+		{"default", func(i *Input) {}, DefaultTrailer},
+		{"custom", func(i *Input) { i.Trailer = "Restart your shell" }, "Restart your shell"},
+		{"disabled", func(i *Input) { i.Trailer = "" }, ""},
+		{"quiet", func(i *Input) { i.Quiet = true }, ""},
+		{"json", func(i *Input) { i.JSON = true }, ""},
+		{"status", func(i *Input) { i.Status = true }, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, home := t.TempDir(), t.TempDir()
+			writeFile(t, filepath.Join(dir, "vimrc"), "")
+			writeFile(t, filepath.Join(dir, "links.json"), `{"vimrc": "~/.vimrc"}`)
+			i := testInput(dir, home)
+			i.Trailer = DefaultTrailer
+			test.setup(&i)
+			stop := captureTerminal(t)
+			_, out := run(t, i)
+			stderr := strings.TrimSpace(stop())
+			if stderr != test.want {
+				t.Errorf("the terminal got %q, want %q", stderr, test.want)
+			}
+			// The report never holds it.
+			if i.Trailer != "" && strings.Contains(out, i.Trailer) {
+				t.Errorf("the report holds the trailer:\n%v", out)
+			}
+		})
+	}
+}
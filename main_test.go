@@ -0,0 +1,1474 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeFile creates the file at path with the contents, along with its missing parent directories.
+func writeFile(t testing.TB, path, contents string) {
+	t.Helper()
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(path, []byte(contents), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// relLinks returns the links as "src -> dest" strings with the sources relative to dir, so links read from different directories can be compared.
+func relLinks(t *testing.T, dir string, links []Link) []string {
+	t.Helper()
+	var s []string
+	for _, l := range links {
+		rel, err := filepath.Rel(dir, l.Src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s = append(s, rel+" -> "+l.Dest)
+	}
+	return s
+}
+
+func TestLinkCmd(t *testing.T) {
+	l := Link{Src: "/dot/vimrc", Dest: "/home/u/.vimrc"}
+	tests := []struct {
+		force bool
+		want  string
+	}{
+		{false, "ln -s /dot/vimrc /home/u/.vimrc"},
+		{true, "ln -sf /dot/vimrc /home/u/.vimrc"},
+	}
+	for _, test := range tests {
+		if got := l.cmd(Input{Force: test.force}); got != test.want {
+			t.Errorf("cmd with force %v = %q, want %q", test.force, got, test.want)
+		}
+	}
+}
+
+func TestCleanPathTilde(t *testing.T) {
+	t.Setenv("HOME", "/home/test")
+	tests := []struct {
+		path, want string
+	}{
+		{"~", "/home/test"},
+		{"~/.bashrc", "/home/test/.bashrc"},
+		{"~/.config/nvim", "/home/test/.config/nvim"},
+		{"/etc/hosts", "/etc/hosts"},
+		{"dotfiles/vimrc", "dotfiles/vimrc"},
+		{"/tmp/~", "/tmp/~"},
+		{"~nosuchuser/x", "~nosuchuser/x"},
+	}
+	for _, test := range tests {
+		got, err := cleanPath(test.path, "", nil)
+		if err != nil {
+			t.Errorf("cleanPath(%q) failed: %v", test.path, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("cleanPath(%q) = %q, want %q", test.path, got, test.want)
+		}
+	}
+}
+
+func TestCleanPathDefault(t *testing.T) {
+	t.Setenv("SET", "value")
+	t.Setenv("EMPTY", "")
+	tests := []struct {
+		path, want string
+	}{
+		{"/home/${SET:-.config}/x", "/home/value/x"},
+		{"/home/${NOPE:-.config}/x", "/home/.config/x"},
+		{"/home/${EMPTY:-.config}/x", "/home/.config/x"},
+		{"/home/${NOPE:-a/b}/x", "/home/a/b/x"},
+		{"/home/${EMPTY:-/abs}/x", "/home/abs/x"},
+		{"/home/$SET/x", "/home/value/x"},
+		{"/home/$NOPE/x", "/home/x"},
+	}
+	for _, test := range tests {
+		got, err := cleanPath(test.path, "", nil)
+		if err != nil {
+			t.Errorf("cleanPath(%q) failed: %v", test.path, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("cleanPath(%q) = %q, want %q", test.path, got, test.want)
+		}
+	}
+}
+
+func TestSymlinkMkdirAll(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "init.vim")
+	writeFile(t, src, "")
+	l := Link{Src: src, Dest: filepath.Join(dir, "home", ".config", "nvim", "lua", "init.vim")}
+	err := l.Symlink(Input{MkdirAll: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !l.linked() {
+		t.Errorf("%v is not linked", l)
+	}
+	if want := filepath.Join(dir, "home"); l.CreatedDir != want {
+		t.Errorf("CreatedDir = %q, want %q", l.CreatedDir, want)
+	}
+
+	// Without MkdirAll the missing parents are an error.
+	l = Link{Src: src, Dest: filepath.Join(dir, "missing", "init.vim")}
+	if err := l.Symlink(Input{}); err == nil {
+		t.Error("linking into a missing directory without MkdirAll succeeded")
+	}
+}
+
+func TestSymlinkMkdirError(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "init.vim")
+	writeFile(t, src, "")
+	// A file in the way of a parent directory can't be replaced by one.
+	writeFile(t, filepath.Join(dir, "file"), "")
+	l := Link{Src: src, Dest: filepath.Join(dir, "file", "nvim", "init.vim")}
+	err := l.Symlink(Input{MkdirAll: true})
+	var merr *MkdirError
+	if !errors.As(err, &merr) {
+		t.Fatalf("Symlink returned %v, want a MkdirError", err)
+	}
+	if want := filepath.Join(dir, "file", "nvim"); merr.Dir != want {
+		t.Errorf("MkdirError.Dir = %q, want %q", merr.Dir, want)
+	}
+}
+
+func TestLinksFormats(t *testing.T) {
+	dir, err := filepath.Abs(filepath.Join("testdata", "formats"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"gitconfig -> /home/test/.config/git/config",
+		"vimrc -> /home/test/.vimrc",
+		"zsh/zshrc.zsh -> /home/test/.zshrc",
+	}
+	for _, name := range []string{"links.json", "links.yaml", "links.toml"} {
+		d := DotDir{Path: dir, LinkFiles: []string{filepath.Join(dir, name)}}
+		links, err := d.Links()
+		if err != nil {
+			t.Errorf("reading %v: %v", name, err)
+			continue
+		}
+		if got := relLinks(t, dir, links); !reflect.DeepEqual(got, want) {
+			t.Errorf("links of %v = %q, want %q", name, got, want)
+		}
+	}
+}
+
+// walkDirs walks dir with b and returns the paths of the DotDirs found relative to dir, "." for dir itself.
+func walkDirs(t *testing.T, b *Bootstrap, dir string) []string {
+	t.Helper()
+	err := b.Walk(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dirs []string
+	for _, d := range b.DotDirs {
+		rel, err := filepath.Rel(dir, d.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dirs = append(dirs, rel)
+	}
+	return dirs
+}
+
+func TestWalkIgnore(t *testing.T) {
+	dir := t.TempDir()
+	for _, d := range []string{"vim", "node_modules", "vendor/lib", "docs/examples", "examples"} {
+		writeFile(t, filepath.Join(dir, d, "links.json"), "{}")
+	}
+	writeFile(t, filepath.Join(dir, IgnoreFile), "# Never searched\nnode_modules\n!vim\nvendor/\n/docs/examples\n")
+	got := walkDirs(t, NewBootstrap(), dir)
+	want := []string{"examples", "vim"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Walk found %q, want %q", got, want)
+	}
+}
+
+func TestLinkConcurrencyOne(t *testing.T) {
+	dir := t.TempDir()
+	b := NewBootstrap()
+	b.Concurrency = 1
+	var want []string
+	for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		d := filepath.Join(dir, name)
+		writeFile(t, filepath.Join(d, "links.json"), `{"1": "/home/test/`+name+`1", "2": "/home/test/`+name+`2"}`)
+		b.AddDir(d, filepath.Join(d, "links.json"))
+		want = append(want, "/home/test/"+name+"1", "/home/test/"+name+"2")
+	}
+	for n := 0; n < 5; n++ {
+		results := make(chan LinkResult)
+		go func() {
+			b.Link(results)
+			close(results)
+		}()
+		var got []string
+		for r := range results {
+			if r.Err != nil {
+				t.Fatal(r.Err)
+			}
+			got = append(got, r.Link.Dest)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("run %v linked %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestSymlinkRelative(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "dotfiles", "vim", "vimrc")
+	writeFile(t, src, "set number")
+	dest := filepath.Join(dir, "home", ".vimrc")
+	writeFile(t, filepath.Join(dir, "home", ".keep"), "")
+	l := Link{Src: src, Dest: dest}
+	err := l.Symlink(Input{Relative: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err := os.Readlink(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join("..", "dotfiles", "vim", "vimrc"); target != want {
+		t.Errorf("the link target is %q, want %q", target, want)
+	}
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	destInfo, err := os.Stat(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(srcInfo, destInfo) {
+		t.Errorf("%v doesn't resolve to %v", dest, src)
+	}
+	// The link still reports the absolute source, and is already linked.
+	if l.Src != src {
+		t.Errorf("Src = %q, want %q", l.Src, src)
+	}
+	if err := l.Symlink(Input{Relative: true}); err != ErrLinked {
+		t.Errorf("relinking returned %v, want ErrLinked", err)
+	}
+}
+
+func TestLinksLocalOverride(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "zsh", "links.json"), `{"zshrc": "/home/test/.zshrc", "zshenv": "/home/test/.zshenv"}`)
+	writeFile(t, filepath.Join(dir, "zsh", "links.local.json"), `{"zshrc": "/home/test/.zshrc.work", "aliases": "/home/test/.aliases"}`)
+	b := NewBootstrap()
+	if got := walkDirs(t, b, dir); !reflect.DeepEqual(got, []string{"zsh"}) {
+		t.Fatalf("Walk found %q, want a single zsh DotDir", got)
+	}
+	links, err := b.DotDirs[0].Links()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"aliases -> /home/test/.aliases",
+		"zshenv -> /home/test/.zshenv",
+		"zshrc -> /home/test/.zshrc.work",
+	}
+	if got := relLinks(t, filepath.Join(dir, "zsh"), links); !reflect.DeepEqual(got, want) {
+		t.Errorf("Links = %q, want %q", got, want)
+	}
+}
+
+func TestLinksObjectEntries(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "links.json"), `{
+	"zshrc": "/home/test/.zshrc",
+	"init.vim": {"dest": "/home/test/.config/nvim/init.vim", "force": true, "mkdir": true},
+	"gitconfig": {"dest": "/home/test/.gitconfig", "copy": true}
+}`)
+	links, err := DotDir{Path: dir, LinkFiles: []string{filepath.Join(dir, "links.json")}}.Links()
+	if err != nil {
+		t.Fatal(err)
+	}
+	type options struct {
+		Dest                  string
+		Force, MkdirAll, Copy bool
+	}
+	want := []options{
+		{"/home/test/.gitconfig", false, false, true},
+		{"/home/test/.config/nvim/init.vim", true, true, false},
+		{"/home/test/.zshrc", false, false, false},
+	}
+	var got []options
+	for _, l := range links {
+		got = append(got, options{l.Dest, l.Force, l.MkdirAll, l.Copy})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Links = %+v, want %+v", got, want)
+	}
+
+	writeFile(t, filepath.Join(dir, "links.json"), `{"zshrc": {"force": true}}`)
+	if _, err := (DotDir{Path: dir, LinkFiles: []string{filepath.Join(dir, "links.json")}}).Links(); err == nil {
+		t.Error("an object without a destination was accepted")
+	}
+}
+
+func TestCleanPathEmbeddedVars(t *testing.T) {
+	t.Setenv("VAR", "value")
+	tests := []struct {
+		path, want string
+	}{
+		{"/etc/$VAR.conf", "/etc/value.conf"},
+		{"/etc/a-$VAR", "/etc/a-value"},
+		{"/etc/${VAR}x", "/etc/valuex"},
+		{"$VAR/a-$VAR-b", "value/a-value-b"},
+	}
+	for _, test := range tests {
+		got, err := cleanPath(test.path, "", nil)
+		if err != nil {
+			t.Errorf("cleanPath(%q) failed: %v", test.path, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("cleanPath(%q) = %q, want %q", test.path, got, test.want)
+		}
+	}
+}
+
+func TestLinksGlob(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"bin/tmux-sessions", "bin/git-prune", "bin/notes.txt"} {
+		writeFile(t, filepath.Join(dir, name), "")
+	}
+	writeFile(t, filepath.Join(dir, "links.json"), `{"bin/*": "/home/test/bin/", "empty/*": "/home/test/empty/"}`)
+	links, err := DotDir{Path: dir, LinkFiles: []string{filepath.Join(dir, "links.json")}}.Links()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"bin/git-prune -> /home/test/bin/git-prune",
+		"bin/notes.txt -> /home/test/bin/notes.txt",
+		"bin/tmux-sessions -> /home/test/bin/tmux-sessions",
+	}
+	if got := relLinks(t, dir, links); !reflect.DeepEqual(got, want) {
+		t.Errorf("Links = %q, want %q", got, want)
+	}
+}
+
+func TestWalkHidden(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), ".dotfiles")
+	writeFile(t, filepath.Join(dir, "links.json"), "{}")
+	writeFile(t, filepath.Join(dir, ".git", "links.json"), "{}")
+	if got, want := walkDirs(t, NewBootstrap(), dir), []string{"."}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Walk found %q, want %q", got, want)
+	}
+	b := NewBootstrap()
+	b.Hidden = true
+	if got, want := walkDirs(t, b, dir), []string{".git", "."}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Walk with Hidden found %q, want %q", got, want)
+	}
+}
+
+func TestWalkMaxDepth(t *testing.T) {
+	dir := t.TempDir()
+	for _, rel := range []string{".", "a", "a/b", "a/b/c"} {
+		writeFile(t, filepath.Join(dir, rel, "links.json"), "{}")
+	}
+	tests := []struct {
+		depth int
+		want  []string
+	}{
+		{-1, []string{"a/b/c", "a/b", "a", "."}},
+		{0, []string{"."}},
+		{1, []string{"a", "."}},
+		{2, []string{"a/b", "a", "."}},
+	}
+	for _, test := range tests {
+		b := NewBootstrap()
+		b.MaxDepth = test.depth
+		var want []string
+		for _, rel := range test.want {
+			want = append(want, filepath.FromSlash(rel))
+		}
+		if got := walkDirs(t, b, dir); !reflect.DeepEqual(got, want) {
+			t.Errorf("Walk with MaxDepth %v found %q, want %q", test.depth, got, want)
+		}
+	}
+}
+
+func TestWalkUnreadable(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can read any directory")
+	}
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "vim", "links.json"), "{}")
+	writeFile(t, filepath.Join(dir, "secret", "links.json"), "{}")
+	err := os.Chmod(filepath.Join(dir, "secret"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(filepath.Join(dir, "secret"), 0755)
+	b := NewBootstrap()
+	if got, want := walkDirs(t, b, dir), []string{"vim"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Walk found %q, want %q", got, want)
+	}
+	if len(b.Warnings) != 1 || !errors.Is(b.Warnings[0], os.ErrPermission) {
+		t.Errorf("Warnings = %v, want a permission error for the unreadable directory", b.Warnings)
+	}
+}
+
+func TestLinkContextCancel(t *testing.T) {
+	dir := t.TempDir()
+	b := NewBootstrap()
+	b.Concurrency = 2
+	for n := 0; n < 20; n++ {
+		d := filepath.Join(dir, strconv.Itoa(n))
+		writeFile(t, filepath.Join(d, "links.json"), `{"a": "/home/test/a", "b": "/home/test/b"}`)
+		b.AddDir(d, filepath.Join(d, "links.json"))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	results := make(chan LinkResult)
+	done := make(chan struct{})
+	go func() {
+		b.LinkContext(ctx, results)
+		close(done)
+	}()
+	// Stop reading after the first result, leaving the rest of the links unsent.
+	<-results
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("LinkContext didn't return once cancelled")
+	}
+}
+
+func TestLinksRecursive(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"config/nvim/init.vim", "config/nvim/lua/plugins.lua", "config/git/config"} {
+		writeFile(t, filepath.Join(dir, name), "")
+	}
+	writeFile(t, filepath.Join(dir, "links.json"), `{"config": {"dest": "/home/test/.config", "recursive": true}}`)
+	links, err := DotDir{Path: dir, LinkFiles: []string{filepath.Join(dir, "links.json")}}.Links()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"config/git/config -> /home/test/.config/git/config",
+		"config/nvim/init.vim -> /home/test/.config/nvim/init.vim",
+		"config/nvim/lua/plugins.lua -> /home/test/.config/nvim/lua/plugins.lua",
+	}
+	if got := relLinks(t, dir, links); !reflect.DeepEqual(got, want) {
+		t.Errorf("Links = %q, want %q", got, want)
+	}
+	for _, l := range links {
+		if !l.MkdirAll {
+			t.Errorf("%v doesn't create its parent directories", l)
+		}
+	}
+}
+
+func TestLinkChain(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "vimrc")
+	writeFile(t, src, "")
+	// dest -> mid -> src
+	mid := filepath.Join(dir, "mid")
+	if err := os.Symlink(src, mid); err != nil {
+		t.Fatal(err)
+	}
+	l := Link{Src: src, Dest: filepath.Join(dir, "dest")}
+	if err := os.Symlink(mid, l.Dest); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Symlink(Input{}); err != ErrLinked {
+		t.Errorf("Symlink over a two hop chain returned %v, want ErrLinked", err)
+	}
+	if state := l.State(); state != StateLinked {
+		t.Errorf("State of a two hop chain = %v, want %v", state, StateLinked)
+	}
+	if state := l.state(newStatCache()); state != StateLinked {
+		t.Errorf("cached state of a two hop chain = %v, want %v", state, StateLinked)
+	}
+	if err := l.Verify(false); err != nil {
+		t.Errorf("Verify of a two hop chain failed: %v", err)
+	}
+
+	// A chain to something else is a wrong link.
+	other := filepath.Join(dir, "other")
+	writeFile(t, other, "")
+	os.Remove(mid)
+	if err := os.Symlink(other, mid); err != nil {
+		t.Fatal(err)
+	}
+	if state := l.State(); state != StateWrongLink {
+		t.Errorf("State of a chain elsewhere = %v, want %v", state, StateWrongLink)
+	}
+
+	// A dangling chain is never linked, even to a missing source.
+	os.Remove(mid)
+	os.Remove(src)
+	if err := os.Symlink(src, mid); err != nil {
+		t.Fatal(err)
+	}
+	if l.linked() {
+		t.Error("a dangling chain is linked")
+	}
+	if state := l.State(); state != StateBroken {
+		t.Errorf("State of a dangling chain = %v, want %v", state, StateBroken)
+	}
+}
+
+func TestSymlinkForceMissingDest(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "vimrc")
+	writeFile(t, src, "")
+	for _, i := range []Input{{Force: true}, {Force: true, Backup: true}, {Force: true, ForceDir: true}} {
+		l := Link{Src: src, Dest: filepath.Join(dir, "dest")}
+		if err := l.Symlink(i); err != nil {
+			t.Errorf("Symlink with %+v and no destination failed: %v", i, err)
+		}
+		if !l.linked() {
+			t.Errorf("Symlink with %+v didn't link %v", i, l)
+		}
+		if l.BackupPath != "" {
+			t.Errorf("Symlink with %+v backed up a missing destination to %v", i, l.BackupPath)
+		}
+		os.Remove(l.Dest)
+	}
+}
+
+func TestLinksProfiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "links.json"), `{
+	"zshrc": "/home/test/.zshrc",
+	"profiles": {
+		"default": {"vimrc": "/home/test/.vimrc"},
+		"laptop": {"battery.zsh": "/home/test/.zsh/battery.zsh", "zshrc": "/home/test/.zshrc.laptop"}
+	}
+}`)
+	tests := []struct {
+		profiles []string
+		want     []string
+	}{
+		{nil, []string{"vimrc -> /home/test/.vimrc", "zshrc -> /home/test/.zshrc"}},
+		{[]string{"unknown"}, []string{"vimrc -> /home/test/.vimrc", "zshrc -> /home/test/.zshrc"}},
+		{[]string{"laptop"}, []string{"battery.zsh -> /home/test/.zsh/battery.zsh", "vimrc -> /home/test/.vimrc", "zshrc -> /home/test/.zshrc.laptop"}},
+	}
+	for _, test := range tests {
+		links, err := DotDir{Path: dir, LinkFiles: []string{filepath.Join(dir, "links.json")}, Profiles: test.profiles}.Links()
+		if err != nil {
+			t.Errorf("the profiles %q failed: %v", test.profiles, err)
+			continue
+		}
+		if got := relLinks(t, dir, links); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("the profiles %q linked %q, want %q", test.profiles, got, test.want)
+		}
+	}
+}
+
+func TestLinksTemplate(t *testing.T) {
+	dir := t.TempDir()
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("EDITOR_NAME", "nvim")
+	writeFile(t, filepath.Join(dir, "links.json"), `{
+	"os": "/home/test/{{.OS}}-{{.Arch}}",
+	"host": "{{.Home}}/{{.Hostname}}/{{.Env.EDITOR_NAME}}"
+}`)
+	links, err := DotDir{Path: dir, LinkFiles: []string{filepath.Join(dir, "links.json")}, Home: "/home/test"}.Links()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"host -> /home/test/" + hostname + "/nvim",
+		"os -> /home/test/" + runtime.GOOS + "-" + runtime.GOARCH,
+	}
+	if got := relLinks(t, dir, links); !reflect.DeepEqual(got, want) {
+		t.Errorf("Links = %q, want %q", got, want)
+	}
+
+	for _, dest := range []string{"/home/test/{{.OS", "/home/test/{{.Nope}}", "/home/test/{{.Env.NO_SUCH_VARIABLE}}"} {
+		writeFile(t, filepath.Join(dir, "links.json"), `{"os": "`+dest+`"}`)
+		_, err := DotDir{Path: dir, LinkFiles: []string{filepath.Join(dir, "links.json")}}.Links()
+		if err == nil || !strings.Contains(err.Error(), "rendering the destination of os") {
+			t.Errorf("the destination %v returned %v, want a rendering error", dest, err)
+		}
+	}
+}
+
+func TestSelfLink(t *testing.T) {
+	tests := []struct {
+		src, dest string
+		err       bool
+	}{
+		{"/dot/vimrc", "/dot/vimrc", true},
+		{"/dot/vim/../vimrc", "/dot/vimrc", true},
+		{"/dot/vim/vimrc", "/dot", true},
+		{"/dot/vim/vimrc", "/dot/vim", true},
+		{"/dot/vimrc", "/home/u/.vimrc", false},
+		{"/dot/vimrc", "/dot/vimrc2", false},
+		{"/dot/..vimrc", "/dot/vimrc", false},
+		{"/dot/vim", "/dot/vim/vimrc", false},
+	}
+	for _, test := range tests {
+		err := Link{Src: test.src, Dest: test.dest}.selfLink()
+		if (err != nil) != test.err {
+			t.Errorf("selfLink of %v -> %v returned %v", test.src, test.dest, err)
+		}
+	}
+}
+
+func TestValidateSelfLink(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "vimrc"), "")
+	writeFile(t, filepath.Join(dir, "vim", "init.vim"), "")
+	writeFile(t, filepath.Join(dir, "links.json"), `{"vimrc": "`+filepath.Join(dir, "vimrc")+`", "vim": "`+dir+`"}`)
+	b := NewBootstrap()
+	b.AddDir(dir, filepath.Join(dir, "links.json"))
+	errs := b.Validate()
+	if len(errs) != 2 {
+		t.Fatalf("Validate returned %v, want the self link and the ancestor", errs)
+	}
+	for n, want := range []string{"vim is inside its destination", "vimrc links to itself"} {
+		if !strings.Contains(errs[n].Error(), want) {
+			t.Errorf("error %v is %q, want it to say %q", n, errs[n], want)
+		}
+	}
+}
+
+func TestReroot(t *testing.T) {
+	tests := []struct {
+		root, dest, want string
+	}{
+		{"/tmp/sandbox", "/etc/foo", "/tmp/sandbox/etc/foo"},
+		{"/tmp/sandbox", "/home/u/.vimrc", "/tmp/sandbox/home/u/.vimrc"},
+		{"", "/etc/foo", "/etc/foo"},
+	}
+	for _, test := range tests {
+		l := Link{Src: "/dot/foo", Dest: test.dest}
+		l.reroot(test.root)
+		if l.Dest != test.want || l.Src != "/dot/foo" {
+			t.Errorf("rerooting %v under %q = %v, want %v", test.dest, test.root, l, test.want)
+		}
+	}
+}
+
+func TestLinksRoot(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "hosts"), "")
+	writeFile(t, filepath.Join(dir, "links.json"), `{"hosts": "/etc/foo"}`)
+	links, err := DotDir{Path: dir, LinkFiles: []string{filepath.Join(dir, "links.json")}, Root: "/tmp/sandbox"}.Links()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := relLinks(t, dir, links), []string{"hosts -> /tmp/sandbox/etc/foo"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Links = %q, want %q", got, want)
+	}
+}
+
+func TestLinksSources(t *testing.T) {
+	dir, other := t.TempDir(), t.TempDir()
+	for _, name := range []string{"vimrc", "bin/a", "bin/b"} {
+		writeFile(t, filepath.Join(other, name), "")
+	}
+	writeFile(t, filepath.Join(dir, "links.json"), `{
+		"`+filepath.Join(other, "vimrc")+`": "/home/test/.vimrc",
+		"`+filepath.Join(other, "bin")+`/*": "/home/test/bin/",
+		"./zshrc": "/home/test/.zshrc",
+		"@dest/nvim/init.vim": "/home/test/.config/init.vim",
+	}`)
+	tests := []struct {
+		root string
+		want []string
+	}{
+		{"", []string{
+			// Absolute, also as a glob pattern.
+			filepath.Join(other, "vimrc") + " -> /home/test/.vimrc",
+			filepath.Join(other, "bin", "a") + " -> /home/test/bin/a",
+			filepath.Join(other, "bin", "b") + " -> /home/test/bin/b",
+			// Relative to the DotDir, as ./ was before the DestSource token.
+			filepath.Join(dir, "zshrc") + " -> /home/test/.zshrc",
+			// Relative to the destination.
+			"/home/test/.config/nvim/init.vim -> /home/test/.config/init.vim",
+		}},
+		// A source relative to the destination is in the sandbox with it.
+		{"/tmp/sandbox", []string{
+			filepath.Join(other, "vimrc") + " -> /tmp/sandbox/home/test/.vimrc",
+			filepath.Join(other, "bin", "a") + " -> /tmp/sandbox/home/test/bin/a",
+			filepath.Join(other, "bin", "b") + " -> /tmp/sandbox/home/test/bin/b",
+			filepath.Join(dir, "zshrc") + " -> /tmp/sandbox/home/test/.zshrc",
+			"/tmp/sandbox/home/test/.config/nvim/init.vim -> /tmp/sandbox/home/test/.config/init.vim",
+		}},
+	}
+	for _, test := range tests {
+		links, err := DotDir{Path: dir, LinkFiles: []string{filepath.Join(dir, "links.json")}, Root: test.root}.Links()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, link := range links {
+			got = append(got, link.Src+" -> "+link.Dest)
+		}
+		sort.Strings(got)
+		want := append([]string(nil), test.want...)
+		sort.Strings(want)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Links with Root %q = %q, want %q", test.root, got, want)
+		}
+	}
+}
+
+func TestNormalizeSeparators(t *testing.T) {
+	if filepath.Separator == '\\' {
+		t.Skip("backslashes are the separator")
+	}
+	tests := []struct {
+		path, want string
+		replaced   bool
+	}{
+		{`foo\bar`, "foo/bar", true},
+		{`~\.config\nvim`, "~/.config/nvim", true},
+		{"foo/bar", "foo/bar", false},
+		// Escaped characters are kept.
+		{`my\ file`, `my\ file`, false},
+		{`\$HOME\.vimrc`, `\$HOME/.vimrc`, true},
+	}
+	for _, test := range tests {
+		got, replaced := normalizeSeparators(test.path)
+		if got != test.want || replaced != test.replaced {
+			t.Errorf("normalizeSeparators(%q) = %q, %v, want %q, %v", test.path, got, replaced, test.want, test.replaced)
+		}
+	}
+}
+
+func TestLinksBackslashes(t *testing.T) {
+	if filepath.Separator == '\\' {
+		t.Skip("backslashes are the separator")
+	}
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "foo", "bar"), "")
+	writeFile(t, filepath.Join(dir, "links.json"), `{"foo\\bar": "/home/u\\.bar"}`)
+	links, err := DotDir{Path: dir, LinkFiles: []string{filepath.Join(dir, "links.json")}}.Links()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := relLinks(t, dir, links), []string{"foo/bar -> /home/u/.bar"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Links = %q, want %q", got, want)
+	}
+}
+
+func TestSymlinkForceDir(t *testing.T) {
+	for _, name := range []string{"empty", "full"} {
+		dir := t.TempDir()
+		l := Link{Src: filepath.Join(dir, "nvim"), Dest: filepath.Join(dir, "config", "nvim")}
+		writeFile(t, filepath.Join(l.Src, "init.vim"), "")
+		if err := os.MkdirAll(l.Dest, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if name == "full" {
+			writeFile(t, filepath.Join(l.Dest, "init.vim"), "mine")
+		}
+
+		// -force alone never removes a directory.
+		var cerr *ErrConflict
+		if err := l.Symlink(Input{Force: true}); !errors.As(err, &cerr) {
+			t.Errorf("forcing over the %v directory returned %v, want an *ErrConflict", name, err)
+		}
+		if err := l.Symlink(Input{ForceDir: true}); err != nil {
+			t.Errorf("replacing the %v directory failed: %v", name, err)
+		}
+		if !l.linked() {
+			t.Errorf("the %v directory wasn't replaced by the link", name)
+		}
+	}
+}
+
+func TestSymlinkForceDirBackup(t *testing.T) {
+	dir := t.TempDir()
+	l := Link{Src: filepath.Join(dir, "nvim"), Dest: filepath.Join(dir, "config", "nvim")}
+	writeFile(t, filepath.Join(l.Src, "init.vim"), "")
+	writeFile(t, filepath.Join(l.Dest, "init.vim"), "mine")
+	if err := l.Symlink(Input{ForceDir: true, Backup: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !l.linked() {
+		t.Error("the directory wasn't replaced by the link")
+	}
+	if data, err := os.ReadFile(filepath.Join(l.BackupPath, "init.vim")); err != nil || string(data) != "mine" {
+		t.Errorf("the backup %v holds %q, %v, want the directory's files", l.BackupPath, data, err)
+	}
+}
+
+func TestSymlinkDangling(t *testing.T) {
+	dir := t.TempDir()
+	l := Link{Src: filepath.Join(dir, "dotfiles", "vimrc"), Dest: filepath.Join(dir, ".vimrc")}
+
+	// A dangling link to the source, not yet created, is ours to recreate, however its target is spelled.
+	if err := os.Symlink(dir+"/dotfiles/./vimrc", l.Dest); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Symlink(Input{}); err != nil {
+		t.Errorf("recreating the dangling link to the source failed: %v", err)
+	}
+	if target, err := os.Readlink(l.Dest); err != nil || target != l.Src {
+		t.Errorf("%v links to %q, %v, want %v", l.Dest, target, err, l.Src)
+	}
+
+	// A dangling link to something else is someone else's.
+	other := filepath.Join(dir, "gone")
+	if err := os.Remove(l.Dest); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(other, l.Dest); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, l.Src, "")
+	if s := l.State(); s != StateBroken {
+		t.Errorf("State = %v, want %v", s, StateBroken)
+	}
+	var berr *BrokenLinkError
+	if err := l.Symlink(Input{}); !errors.As(err, &berr) || berr.Target != other {
+		t.Errorf("linking over the dangling link to %v returned %v, want a *BrokenLinkError", other, err)
+	}
+	if target, err := os.Readlink(l.Dest); err != nil || target != other {
+		t.Errorf("the dangling link was changed to %q, %v", target, err)
+	}
+	if err := l.Symlink(Input{Force: true}); err != nil || !l.linked() {
+		t.Errorf("forcing over the dangling link failed: %v", err)
+	}
+}
+
+func TestCleanPathAllowedEnv(t *testing.T) {
+	t.Setenv("HOME", "/home/test")
+	t.Setenv("SECRET", "hunter2")
+	t.Setenv("DOTFILES", "/dot")
+	allowed := []string{"HOME", "DOTFILES", "UNSET"}
+	tests := []struct {
+		path, want string
+		fail       bool
+	}{
+		{"$HOME/.vimrc", "/home/test/.vimrc", false},
+		{"${DOTFILES}/vimrc", "/dot/vimrc", false},
+		{"/x/${UNSET:-y}", "/x/y", false},
+		{"/x/$UNSET/y", "/x/y", false},
+		{"/x/$SECRET", "", true},
+		{"/x/${SECRET:-y}", "", true},
+		{"/x/$NOTLISTED", "", true},
+		// ~ is allowed whatever the list.
+		{"~/.vimrc", "/home/test/.vimrc", false},
+	}
+	for _, test := range tests {
+		got, err := cleanPath(test.path, "", allowed)
+		if test.fail {
+			if err == nil || strings.Contains(err.Error(), "hunter2") {
+				t.Errorf("cleanPath(%q) = %q, %v, want an error naming the variable", test.path, got, err)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("cleanPath(%q) = %q, %v, want %q", test.path, got, err, test.want)
+		}
+	}
+	// Without a list, every variable is expanded.
+	if got, err := cleanPath("/x/$SECRET", "", nil); err != nil || got != "/x/hunter2" {
+		t.Errorf("cleanPath without a list = %q, %v, want /x/hunter2", got, err)
+	}
+}
+
+func TestCleanPathXDG(t *testing.T) {
+	t.Setenv("HOME", "/home/test")
+	tests := []struct {
+		token, variable, unset string
+	}{
+		{"@config", "XDG_CONFIG_HOME", "/home/test/.config"},
+		{"@data", "XDG_DATA_HOME", "/home/test/.local/share"},
+		{"@state", "XDG_STATE_HOME", "/home/test/.local/state"},
+		{"@cache", "XDG_CACHE_HOME", "/home/test/.cache"},
+	}
+	for _, test := range tests {
+		t.Setenv(test.variable, "")
+		if got, err := cleanPath(test.token+"/app/rc", "", nil); err != nil || got != test.unset+"/app/rc" {
+			t.Errorf("cleanPath(%q) with $%v unset = %q, %v, want %q", test.token+"/app/rc", test.variable, got, err, test.unset+"/app/rc")
+		}
+		t.Setenv(test.variable, "/xdg/"+test.token[1:])
+		if got, err := cleanPath(test.token+"/app/rc", "", nil); err != nil || got != "/xdg/"+test.token[1:]+"/app/rc" {
+			t.Errorf("cleanPath(%q) with $%v set = %q, %v, want %q", test.token+"/app/rc", test.variable, got, err, "/xdg/"+test.token[1:]+"/app/rc")
+		}
+	}
+
+	// Unknown tokens and tokens after the first element are left alone.
+	for _, path := range []string{"@nope/rc", "/etc/@config"} {
+		if got, err := cleanPath(path, "", nil); err != nil || got != path {
+			t.Errorf("cleanPath(%q) = %q, %v, want it unchanged", path, got, err)
+		}
+	}
+}
+
+// captureLog returns the buffer receiving the log package's output until the end of the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+func TestLinksErrors(t *testing.T) {
+	logged := captureLog(t)
+	dir := t.TempDir()
+	linkFile := filepath.Join(dir, "links.json")
+	writeFile(t, linkFile, `{"vimrc": `)
+	_, err := DotDir{Path: dir, LinkFiles: []string{linkFile}}.Links()
+	var serr *json.SyntaxError
+	if err == nil || !strings.HasPrefix(err.Error(), "parsing "+linkFile+": ") || !errors.As(err, &serr) {
+		t.Errorf("Links of a malformed file returned %v, want a wrapped *json.SyntaxError", err)
+	}
+
+	writeFile(t, linkFile, `{"vimrc": ""}`)
+	if _, err := (DotDir{Path: dir, LinkFiles: []string{linkFile}}).Links(); err == nil || err.Error() != "parsing "+linkFile+": missing destination for vimrc" {
+		t.Errorf("Links of an entry without a destination returned %v", err)
+	}
+
+	missing := filepath.Join(dir, "missing.json")
+	if _, err := (DotDir{Path: dir, LinkFiles: []string{missing}}).Links(); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Links of a missing file returned %v, want one wrapping os.ErrNotExist", err)
+	}
+
+	// The errors are left to the caller to report.
+	if logged.Len() > 0 {
+		t.Errorf("Links logged %q", logged)
+	}
+}
+
+// records is a slog.Handler keeping the message of every record, whatever its level.
+type records struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (r *records) Enabled(context.Context, slog.Level) bool { return true }
+
+func (r *records) Handle(_ context.Context, rec slog.Record) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.msgs = append(r.msgs, rec.Message)
+	return nil
+}
+
+func (r *records) WithAttrs([]slog.Attr) slog.Handler { return r }
+
+func (r *records) WithGroup(string) slog.Handler { return r }
+
+func TestLogger(t *testing.T) {
+	old := Verbosity
+	Verbosity = LevelDebug
+	t.Cleanup(func() { Verbosity = old })
+	logged := captureLog(t)
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "vimrc"), "")
+	writeFile(t, filepath.Join(dir, "links.json"), `{"_comment": "notes", "vimrc": "/home/test/.vimrc", "hooks": {"post": ["echo ran"]}}`)
+	run := func(b *Bootstrap) {
+		b.AddDir(dir, filepath.Join(dir, "links.json"))
+		links, hooks, err := b.DotDirs[0].Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(links) != 1 || links[0].Logger != b.Logger {
+			t.Errorf("Links = %v, want one link with the Logger of the Bootstrap", links)
+		}
+		if _, err := b.DotDirs[0].RunHooks("post", hooks); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := &records{}
+	run(NewBootstrap(WithLogger(slog.New(r))))
+	if want := []string{"Skipping a note", "Ran hook"}; !reflect.DeepEqual(r.msgs, want) {
+		t.Errorf("logged %q, want %q", r.msgs, want)
+	}
+
+	// Only the Logger is logged to, not the log package.
+	run(NewBootstrap())
+	if logged.Len() > 0 {
+		t.Errorf("a Bootstrap without a Logger logged %q", logged)
+	}
+}
+
+func TestSymlinkForceAtomic(t *testing.T) {
+	dir := t.TempDir()
+	dest := filepath.Join(dir, ".vimrc")
+	writeFile(t, dest, "mine")
+	links := []Link{
+		{Src: filepath.Join(dir, "a"), Dest: dest},
+		{Src: filepath.Join(dir, "b"), Dest: dest},
+	}
+
+	// Watch Dest while it is relinked back and forth.
+	done := make(chan struct{})
+	missing := make(chan error, 1)
+	go func() {
+		defer close(missing)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if _, err := os.Lstat(dest); err != nil {
+				missing <- err
+				return
+			}
+		}
+	}()
+	for n := 0; n < 200; n++ {
+		l := links[n%2]
+		if err := l.Symlink(Input{Force: true}); err != nil {
+			t.Fatal(err)
+		}
+		if target, err := os.Readlink(dest); err != nil || target != l.Src {
+			t.Fatalf("%v links to %q, %v, want %v", dest, target, err, l.Src)
+		}
+	}
+	close(done)
+	if err := <-missing; err != nil {
+		t.Errorf("the destination went missing while being replaced: %v", err)
+	}
+
+	// The temporary links are all renamed into place.
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 {
+		t.Errorf("%v holds %v, %v, want only the link", dir, entries, err)
+	}
+}
+
+func TestLinksDirLinks(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "nvim", "init.vim"), "")
+	writeFile(t, filepath.Join(dir, "nvim", "lua", "plugins.lua"), "")
+	writeFile(t, filepath.Join(dir, "git", "config"), "")
+	writeFile(t, filepath.Join(dir, "links.json"), `{"nvim": "/home/test/.config/nvim", "git": {"dest": "/home/test/.config/git", "recursive": true}}`)
+	tests := []struct {
+		expand bool
+		want   []string
+	}{
+		// -dir-links, the default, links the directory itself unless the entry is recursive.
+		{false, []string{
+			"git/config -> /home/test/.config/git/config",
+			"nvim -> /home/test/.config/nvim",
+		}},
+		// Without it, every directory is expanded.
+		{true, []string{
+			"git/config -> /home/test/.config/git/config",
+			"nvim/init.vim -> /home/test/.config/nvim/init.vim",
+			"nvim/lua/plugins.lua -> /home/test/.config/nvim/lua/plugins.lua",
+		}},
+	}
+	for _, test := range tests {
+		links, err := DotDir{Path: dir, LinkFiles: []string{filepath.Join(dir, "links.json")}, ExpandDirs: test.expand}.Links()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := relLinks(t, dir, links); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Links with ExpandDirs %v = %q, want %q", test.expand, got, test.want)
+		}
+	}
+}
+
+func TestInputHome(t *testing.T) {
+	t.Setenv("HOME", "/home/real")
+	if got := (Input{}).Home(); got != "/home/real" {
+		t.Errorf("Home = %q, want $HOME", got)
+	}
+	if got := (Input{HomeDir: "/home/test"}).Home(); got != "/home/test" {
+		t.Errorf("Home = %q, want the override", got)
+	}
+}
+
+func TestLinksHome(t *testing.T) {
+	t.Setenv("HOME", "/home/real")
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b", "c", "d"} {
+		writeFile(t, filepath.Join(dir, name), "")
+	}
+	writeFile(t, filepath.Join(dir, "links.json"), `{"a": "~/a", "b": "$HOME/b", "c": "${HOME}/c", "d": "@config/d"}`)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	links, err := DotDir{Path: dir, LinkFiles: []string{filepath.Join(dir, "links.json")}, Home: "/home/test"}.Links()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a -> /home/test/a", "b -> /home/test/b", "c -> /home/test/c", "d -> /home/test/.config/d"}
+	if got := relLinks(t, dir, links); !reflect.DeepEqual(got, want) {
+		t.Errorf("Links = %q, want %q", got, want)
+	}
+}
+
+func TestLinksSlice(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "vim", "links.json"), `{"vimrc": "/home/test/.vimrc", "gvimrc": "/home/test/.gvimrc"}`)
+	writeFile(t, filepath.Join(dir, "zsh", "links.json"), `{"zshrc": "/home/test/.zshrc"}`)
+	writeFile(t, filepath.Join(dir, "bad", "links.json"), `{"x": `)
+	b := NewBootstrap()
+	b.Concurrency = 1
+	if err := b.Walk(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	results := make(chan LinkResult)
+	go func() {
+		b.Link(results)
+		close(results)
+	}()
+	var wantLinks []Link
+	var wantErrs []string
+	for r := range results {
+		if r.Err != nil {
+			wantErrs = append(wantErrs, r.Err.Error())
+			continue
+		}
+		wantLinks = append(wantLinks, r.Link)
+	}
+
+	links, errs := b.LinksSlice()
+	if !reflect.DeepEqual(links, wantLinks) {
+		t.Errorf("LinksSlice returned %v, want %v", links, wantLinks)
+	}
+	var gotErrs []string
+	for _, err := range errs {
+		gotErrs = append(gotErrs, err.Error())
+	}
+	if len(wantLinks) != 3 || len(wantErrs) != 1 || !reflect.DeepEqual(gotErrs, wantErrs) {
+		t.Errorf("LinksSlice returned the errors %q, want %q", gotErrs, wantErrs)
+	}
+}
+
+func TestLinksMirror(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{".zshrc", ".config/nvim/init.vim", ".config/secrets/token", "README.md", ".git/HEAD"} {
+		writeFile(t, filepath.Join(dir, name), "")
+	}
+	writeFile(t, filepath.Join(dir, IgnoreFile), ".config/secrets\nREADME.md\n")
+	links, err := DotDir{Path: dir, Home: "/home/test", Mirror: true}.Links()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		".config/nvim/init.vim -> /home/test/.config/nvim/init.vim",
+		".zshrc -> /home/test/.zshrc",
+	}
+	if got := relLinks(t, dir, links); !reflect.DeepEqual(got, want) {
+		t.Errorf("Links = %q, want %q", got, want)
+	}
+}
+
+func TestWalkLinkFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a", "links.json"), "{}")
+	writeFile(t, filepath.Join(dir, "b", "dotlinks.json"), "{}")
+	writeFile(t, filepath.Join(dir, "c", "links.json"), "{}")
+	writeFile(t, filepath.Join(dir, "c", "dotlinks.json"), "{}")
+	writeFile(t, filepath.Join(dir, "d", "other.json"), "{}")
+	tests := []struct {
+		linkFiles []string
+		want      map[string]string
+	}{
+		{[]string{"links.json", "dotlinks.json"}, map[string]string{"a": "links.json", "b": "dotlinks.json", "c": "links.json"}},
+		// The precedence follows the order of the names.
+		{[]string{"dotlinks.json", "links.json"}, map[string]string{"a": "links.json", "b": "dotlinks.json", "c": "dotlinks.json"}},
+	}
+	for _, test := range tests {
+		b := NewBootstrap()
+		b.LinkFiles = test.linkFiles
+		if err := b.Walk(dir); err != nil {
+			t.Fatal(err)
+		}
+		got := map[string]string{}
+		for _, d := range b.DotDirs {
+			rel, _ := filepath.Rel(dir, d.Path)
+			got[rel] = d.LinkFile
+			// Only the matched links file is read.
+			if want := []string{filepath.Join(d.Path, d.LinkFile)}; !reflect.DeepEqual(d.LinkFiles, want) {
+				t.Errorf("the LinkFiles of %v are %q, want %q", rel, d.LinkFiles, want)
+			}
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Walk with the names %q matched %v, want %v", test.linkFiles, got, test.want)
+		}
+	}
+}
+
+func TestLinksIntoDir(t *testing.T) {
+	dir, home := t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(dir, "vimrc"), "")
+	writeFile(t, filepath.Join(dir, "gitconfig"), "")
+	writeFile(t, filepath.Join(dir, "nvim", "init.vim"), "")
+	if err := os.MkdirAll(filepath.Join(home, ".config", "git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, "links.json"), `{
+		"vimrc": "~/.vim/",
+		"gitconfig": "~/.config/git",
+		"nvim": "~/.config/nvim"
+	}`)
+	links, err := DotDir{Path: dir, LinkFiles: []string{filepath.Join(dir, "links.json")}, Home: home}.Links()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		// An existing directory gets the name of a file source.
+		"gitconfig -> " + filepath.Join(home, ".config", "git", "gitconfig"),
+		// A missing directory doesn't, so the directory source replaces it.
+		"nvim -> " + filepath.Join(home, ".config", "nvim"),
+		// A trailing slash always does.
+		"vimrc -> " + filepath.Join(home, ".vim", "vimrc"),
+	}
+	if got := relLinks(t, dir, links); !reflect.DeepEqual(got, want) {
+		t.Errorf("Links = %q, want %q", got, want)
+	}
+}
+
+func TestSymlinkSymlinksOnly(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "vimrc")
+	writeFile(t, src, "")
+	tests := []struct {
+		name     string
+		setup    func(dest string) error
+		replaced bool
+	}{
+		{"symlink", func(dest string) error { return os.Symlink(filepath.Join(dir, "other"), dest) }, true},
+		{"file", func(dest string) error { return os.WriteFile(dest, []byte("mine"), 0644) }, false},
+		{"directory", func(dest string) error { return os.Mkdir(dest, 0755) }, false},
+	}
+	for _, test := range tests {
+		l := Link{Src: src, Dest: filepath.Join(dir, test.name)}
+		if err := test.setup(l.Dest); err != nil {
+			t.Fatal(err)
+		}
+		// Even forcing doesn't replace a real file or directory.
+		err := l.Symlink(Input{SymlinksOnly: true, Force: true, ForceDir: true})
+		var cerr *ErrConflict
+		if test.replaced && (err != nil || !l.linked()) {
+			t.Errorf("replacing the %v returned %v", test.name, err)
+		}
+		if !test.replaced && (!errors.As(err, &cerr) || l.linked()) {
+			t.Errorf("linking over the %v returned %v, want an *ErrConflict", test.name, err)
+		}
+	}
+}
+
+func TestWalkLinkFileGlob(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "apps", "a.links.json"), `{"a": "/home/test/a"}`)
+	writeFile(t, filepath.Join(dir, "apps", "b.links.json"), `{"b": "/home/test/b"}`)
+	writeFile(t, filepath.Join(dir, "apps", "notes.json"), `{"c": "/home/test/c"}`)
+	writeFile(t, filepath.Join(dir, "vim", "links.json"), `{"vimrc": "/home/test/.vimrc"}`)
+	b := NewBootstrap()
+	b.LinkFileGlob = "*.links.json"
+	if err := b.Walk(dir); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string][]string{}
+	for _, d := range b.DotDirs {
+		rel, _ := filepath.Rel(dir, d.Path)
+		for _, linkFile := range d.LinkFiles {
+			got[rel] = append(got[rel], filepath.Base(linkFile))
+		}
+	}
+	// Every match is used, along with the usual links files.
+	want := map[string][]string{"apps": {"a.links.json", "b.links.json"}, "vim": {"links.json"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Walk found %v, want %v", got, want)
+	}
+	links, errs := b.LinksSlice()
+	if len(errs) > 0 || len(links) != 3 {
+		t.Errorf("the links files hold %v, %v, want 3 links", links, errs)
+	}
+
+	b.LinkFileGlob = "["
+	if err := b.Walk(dir); err == nil {
+		t.Error("Walk accepted a malformed pattern")
+	}
+}
+
+func TestLinksMetadataKeys(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "vimrc"), "")
+	writeFile(t, filepath.Join(dir, "links.json"), `{
+	"_comment": "Only the editor for now",
+	"_notes": {"why": ["anything", 1]},
+	"vimrc": "/home/test/.vimrc",
+	"profiles": {"work": {"_todo": "add the VPN config"}}
+}`)
+	links, err := DotDir{Path: dir, LinkFiles: []string{filepath.Join(dir, "links.json")}, Profiles: []string{"work"}}.Links()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := relLinks(t, dir, links), []string{"vimrc -> /home/test/.vimrc"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Links = %q, want %q", got, want)
+	}
+}
+
+func TestBackup(t *testing.T) {
+	dir := t.TempDir()
+	l := Link{Dest: filepath.Join(dir, "home", ".vimrc")}
+	writeFile(t, l.Dest, "mine")
+	if err := l.Backup(""); err != nil {
+		t.Fatal(err)
+	}
+	// By default the backup is next to Dest.
+	if filepath.Dir(l.BackupPath) != filepath.Dir(l.Dest) || !strings.HasPrefix(filepath.Base(l.BackupPath), ".vimrc.bak.") {
+		t.Errorf("Dest was backed up to %v", l.BackupPath)
+	}
+	if data, err := os.ReadFile(l.BackupPath); err != nil || string(data) != "mine" {
+		t.Errorf("the backup holds %q, %v", data, err)
+	}
+	if _, err := os.Lstat(l.Dest); !os.IsNotExist(err) {
+		t.Error("Dest is still there")
+	}
+}
+
+func TestBackupDir(t *testing.T) {
+	dir, backups := t.TempDir(), t.TempDir()
+	l := Link{Dest: filepath.Join(dir, "home", ".config", "git", "config")}
+	writeFile(t, l.Dest, "mine")
+	if err := l.Backup(backups); err != nil {
+		t.Fatal(err)
+	}
+	// The full path of Dest is kept under the backup directory.
+	if want := filepath.Join(backups, l.Dest) + ".bak."; !strings.HasPrefix(l.BackupPath, want) {
+		t.Errorf("Dest was backed up to %v, want %v...", l.BackupPath, want)
+	}
+	if data, err := os.ReadFile(l.BackupPath); err != nil || string(data) != "mine" {
+		t.Errorf("the backup holds %q, %v", data, err)
+	}
+
+	// Nothing is created for a missing Dest.
+	empty := t.TempDir()
+	missing := Link{Dest: filepath.Join(dir, "missing", ".vimrc")}
+	if err := missing.Backup(empty); !os.IsNotExist(err) {
+		t.Errorf("backing up a missing Dest returned %v, want a not exist error", err)
+	}
+	if entries, _ := os.ReadDir(empty); len(entries) > 0 {
+		t.Errorf("backing up a missing Dest created %v", entries)
+	}
+}
+
+func TestBackupPathCollision(t *testing.T) {
+	dir := t.TempDir()
+	dest := filepath.Join(dir, ".vimrc")
+	for {
+		// Take the path the backup would use, unless the second changes in between.
+		now := time.Now().Format(BackupTimeFormat)
+		base := fmt.Sprintf("%v.bak.%v", dest, now)
+		writeFile(t, base, "")
+		writeFile(t, base+".1", "")
+		path := backupPath(dest, "")
+		if time.Now().Format(BackupTimeFormat) != now {
+			continue
+		}
+		if want := base + ".2"; path != want {
+			t.Errorf("backupPath = %v, want %v", path, want)
+		}
+		break
+	}
+}
+
+func TestSymlinkBackupMissingParent(t *testing.T) {
+	dir := t.TempDir()
+	l := Link{Src: filepath.Join(dir, "vimrc"), Dest: filepath.Join(dir, "missing", ".vimrc")}
+	writeFile(t, l.Src, "")
+	// Backing up doesn't make the parent directories only -mkdir creates.
+	if err := l.Symlink(Input{Force: true, Backup: true}); err == nil {
+		t.Error("linking into a missing directory without -mkdir succeeded")
+	}
+	if _, err := os.Lstat(filepath.Dir(l.Dest)); !os.IsNotExist(err) {
+		t.Errorf("%v was created", filepath.Dir(l.Dest))
+	}
+}
+
+func TestReadDirs(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "zsh", "links.json"), "{}")
+	writeFile(t, filepath.Join(dir, "vim", "links.json"), "{}")
+	writeFile(t, filepath.Join(dir, "vim", "work.json"), "{}")
+	other := t.TempDir()
+	writeFile(t, filepath.Join(other, "links.json"), "{}")
+	list := filepath.Join(dir, "dirs.txt")
+	writeFile(t, list, "# In this order\nzsh\n\nvim:work.json\n"+other+"\n")
+	b := NewBootstrap()
+	if err := b.ReadDirs(list); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, d := range b.DotDirs {
+		got = append(got, strings.Join(d.LinkFiles, ","))
+	}
+	want := []string{
+		filepath.Join(dir, "zsh", "links.json"),
+		filepath.Join(dir, "vim", "work.json"),
+		filepath.Join(other, "links.json"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadDirs added %q, want %q", got, want)
+	}
+
+	writeFile(t, list, "zsh\nnone\n")
+	if err := NewBootstrap().ReadDirs(list); err == nil || !strings.HasPrefix(err.Error(), list+":2: no links file") {
+		t.Errorf("ReadDirs of a directory without a links file returned %v", err)
+	}
+}
+
+func TestWalkSymlinkLoop(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "vim", "links.json"), "{}")
+	writeFile(t, filepath.Join(dir, "shared", "zsh", "links.json"), "{}")
+	// A link back to the root, and another to a directory already in the tree.
+	if err := os.Symlink(dir, filepath.Join(dir, "vim", "loop")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "shared"), filepath.Join(dir, "work")); err != nil {
+		t.Fatal(err)
+	}
+	b := NewBootstrap()
+	b.FollowSymlinks = true
+	// Each real directory is searched once.
+	if got, want := walkDirs(t, b, dir), []string{"shared/zsh", "vim"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Walk found %q, want %q", got, want)
+	}
+}
+
+func TestBackupDirFile(t *testing.T) {
+	dir := t.TempDir()
+	l := Link{Dest: filepath.Join(dir, ".vimrc")}
+	writeFile(t, l.Dest, "mine")
+	backups := filepath.Join(dir, "backups")
+	writeFile(t, backups, "")
+	if err := l.Backup(backups); err == nil {
+		t.Error("backing up into a file succeeded")
+	}
+	if data, err := os.ReadFile(l.Dest); err != nil || string(data) != "mine" {
+		t.Errorf("Dest holds %q, %v, want it untouched", data, err)
+	}
+}
@@ -0,0 +1,252 @@
+//go:build unix
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReadLinksTimeout(t *testing.T) {
+	dir := t.TempDir()
+	// Opening a FIFO blocks until something writes to it, like a file on a stalled mount.
+	fifo := filepath.Join(dir, "links.json")
+	if err := syscall.Mkfifo(fifo, 0644); err != nil {
+		t.Skip(err)
+	}
+	b := NewBootstrap()
+	b.Timeout = 50 * time.Millisecond
+	start := time.Now()
+	_, err := b.readLinks(DotDir{Path: dir, LinkFiles: []string{fifo}})
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("reading a stalled links file returned %v, want ErrTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("the read was abandoned after %v", elapsed)
+	}
+	// Let the abandoned read finish.
+	if f, err := os.OpenFile(fifo, os.O_WRONLY, 0); err == nil {
+		f.Write([]byte("{}"))
+		f.Close()
+	}
+}
+
+func TestRunStalledLinksFile(t *testing.T) {
+	dir, home := t.TempDir(), t.TempDir()
+	fifo := filepath.Join(dir, "stalled", "links.json")
+	if err := os.MkdirAll(filepath.Dir(fifo), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Mkfifo(fifo, 0644); err != nil {
+		t.Skip(err)
+	}
+	// Let the abandoned reads finish.
+	defer func() {
+		if f, err := os.OpenFile(fifo, os.O_WRONLY|syscall.O_NONBLOCK, 0); err == nil {
+			f.Close()
+		}
+	}()
+	writeFile(t, filepath.Join(dir, "zsh", "zshrc"), "")
+	writeFile(t, filepath.Join(dir, "zsh", "links.json"), `{"zshrc": "~/.zshrc", "hooks": {"pre": ["true"], "post": ["true"]}}`)
+	// Hooks are only run when linking, not in a dry run.
+	for _, dry := range []bool{false, true} {
+		i := testInput(dir, home)
+		i.Timeout, i.Force, i.Dry = 100*time.Millisecond, true, dry
+		start := time.Now()
+		done := make(chan Summary, 1)
+		go func() {
+			summary, _ := Run(i, &bytes.Buffer{})
+			done <- summary
+		}()
+		select {
+		case summary := <-done:
+			if summary.Errors != 1 {
+				t.Errorf("a run with a stalled links file returned %+v, want 1 error", summary)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("a run with a stalled links file and Dry %v didn't return after %v", dry, time.Since(start))
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(home, ".zshrc")); err != nil {
+		t.Errorf("the links of the other DotDir weren't created: %v", err)
+	}
+}
+
+func TestSymlinkHardlink(t *testing.T) {
+	dir := t.TempDir()
+	l := Link{Src: filepath.Join(dir, "vimrc"), Dest: filepath.Join(dir, ".vimrc")}
+	writeFile(t, l.Src, "set nu")
+	if same, err := sameDevice(l.Src, dir); err != nil || !same {
+		t.Fatalf("sameDevice of a file and its directory = %v, %v", same, err)
+	}
+	if err := l.Symlink(Input{Copy: true, Hardlink: true}); err != nil {
+		t.Fatal(err)
+	}
+	src, _ := os.Stat(l.Src)
+	dest, err := os.Lstat(l.Dest)
+	if err != nil || !l.Copied || !l.Hardlinked || !os.SameFile(src, dest) {
+		t.Errorf("the file on the same filesystem wasn't hard linked: %+v, %v", l, err)
+	}
+
+	// A directory can't be hard linked, so it is copied.
+	d := Link{Src: filepath.Join(dir, "nvim"), Dest: filepath.Join(dir, ".nvim")}
+	writeFile(t, filepath.Join(d.Src, "init.vim"), "")
+	if err := d.Symlink(Input{Copy: true, Hardlink: true}); err != nil || !d.Copied || d.Hardlinked {
+		t.Errorf("copying the directory returned %v, %+v", err, d)
+	}
+}
+
+func TestSymlinkHardlinkOtherDevice(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "vimrc")
+	writeFile(t, src, "set nu")
+	// Look for a writable directory on another filesystem.
+	var other string
+	for _, candidate := range []string{"/dev/shm", os.TempDir(), os.Getenv("HOME")} {
+		if same, err := sameDevice(src, candidate); err == nil && !same && writable(candidate) == nil {
+			other = candidate
+			break
+		}
+	}
+	if other == "" {
+		t.Skip("no writable directory on another filesystem")
+	}
+	destDir, err := os.MkdirTemp(other, "bootstrap-test")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer os.RemoveAll(destDir)
+	l := Link{Src: src, Dest: filepath.Join(destDir, ".vimrc")}
+	if err := l.Symlink(Input{Copy: true, Hardlink: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !l.Copied || l.Hardlinked {
+		t.Errorf("the file on another filesystem wasn't copied: %+v", l)
+	}
+	if data, err := os.ReadFile(l.Dest); err != nil || string(data) != "set nu" {
+		t.Errorf("the copy holds %q, %v", data, err)
+	}
+}
+
+func TestSymlinkPermission(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can write to any directory")
+	}
+	dir := t.TempDir()
+	parent := filepath.Join(dir, "etc")
+	writeFile(t, filepath.Join(parent, "hosts"), "mine")
+	if err := os.Chmod(parent, 0555); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(parent, 0755)
+	l := Link{Src: filepath.Join(dir, "hosts"), Dest: filepath.Join(parent, "hosts")}
+	err := l.Symlink(Input{Force: true})
+	var perr *ErrPermission
+	if !errors.As(err, &perr) || perr.Dir != parent || !errors.Is(err, os.ErrPermission) {
+		t.Fatalf("linking into a read-only directory returned %v, want an *ErrPermission", err)
+	}
+	if !strings.Contains(err.Error(), "sudo") {
+		t.Errorf("the error %q doesn't suggest sudo", err)
+	}
+	// Nothing was removed before failing.
+	if data, err := os.ReadFile(l.Dest); err != nil || string(data) != "mine" {
+		t.Errorf("%v holds %q, %v, want it untouched", l.Dest, data, err)
+	}
+}
+
+func TestSymlinkCopyMode(t *testing.T) {
+	dir, home := t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(dir, "netrc"), "")
+	writeFile(t, filepath.Join(dir, "vimrc"), "")
+	writeFile(t, filepath.Join(dir, "links.json"), `{
+		"netrc": {"dest": "`+filepath.Join(home, ".netrc")+`", "copy": true, "mode": "0600"},
+		"vimrc": {"dest": "`+filepath.Join(home, ".vimrc")+`", "mode": "0600"}
+	}`)
+	links, err := DotDir{Path: dir, LinkFiles: []string{filepath.Join(dir, "links.json")}}.Links()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range links {
+		if err := l.Symlink(Input{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(home, ".netrc")); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("the copy has mode %v, %v, want 0600", info.Mode(), err)
+	}
+	// A symlink leaves the mode of its source alone.
+	if info, err := os.Stat(filepath.Join(dir, "vimrc")); err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("the linked source has mode %v, %v, want 0644", info.Mode(), err)
+	}
+
+	// A copy left unchanged still gets the mode.
+	if err := os.Chmod(filepath.Join(home, ".netrc"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := links[0].Symlink(Input{}); err != ErrCopied {
+		t.Fatalf("copying again returned %v, want ErrCopied", err)
+	}
+	if info, err := os.Stat(filepath.Join(home, ".netrc")); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("the unchanged copy has mode %v, %v, want 0600", info.Mode(), err)
+	}
+
+	// A link with a mode is copied rather than hard linked, which would change the source's mode too.
+	l := links[0]
+	l.Dest = filepath.Join(dir, ".netrc")
+	if err := l.Symlink(Input{Hardlink: true}); err != nil || !l.Copied || l.Hardlinked {
+		t.Fatalf("hard linking with a mode returned %v, %+v", err, l)
+	}
+	if info, err := os.Stat(l.Dest); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("the copy instead of a hard link has mode %v, %v, want 0600", info.Mode(), err)
+	}
+	if info, err := os.Stat(l.Src); err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("the source has mode %v, %v, want 0644", info.Mode(), err)
+	}
+
+	writeFile(t, filepath.Join(dir, "links.json"), `{"netrc": {"dest": "~/.netrc", "copy": true, "mode": "rw-------"}}`)
+	if _, err := (DotDir{Path: dir, LinkFiles: []string{filepath.Join(dir, "links.json")}}).Links(); err == nil || !strings.Contains(err.Error(), "is not an octal permission") {
+		t.Errorf("Links of a mode that isn't octal returned %v", err)
+	}
+}
+
+func TestSymlinkCopyOwnerUnprivileged(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root may change the owner")
+	}
+	dir := t.TempDir()
+	r := &records{}
+	l := Link{Src: filepath.Join(dir, "hosts"), Dest: filepath.Join(dir, "hosts.copy"), Owner: "0", Mode: 0640, Logger: slog.New(r)}
+	writeFile(t, l.Src, "127.0.0.1 localhost")
+	// Not being allowed to change the owner keeps the copy, with its mode, and is only a warning.
+	if err := l.Symlink(Input{Copy: true}); err != nil {
+		t.Fatalf("copying to an owner without the privileges returned %v", err)
+	}
+	info, err := os.Stat(l.Dest)
+	if err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("the copy has mode %v, %v, want 0640", info.Mode(), err)
+	}
+	if info.Sys().(*syscall.Stat_t).Uid != uint32(os.Geteuid()) {
+		t.Error("the owner of the copy changed")
+	}
+	if len(r.msgs) != 1 || !strings.Contains(r.msgs[0], "owner") {
+		t.Errorf("logged %q, want a warning about the owner", r.msgs)
+	}
+
+	// As is copying it again, leaving it unchanged.
+	if err := l.Symlink(Input{Copy: true}); err != ErrCopied || len(r.msgs) != 2 {
+		t.Errorf("copying again returned %v and logged %q, want ErrCopied and another warning", err, r.msgs)
+	}
+
+	// An unknown user is an error though.
+	l.Dest, l.Owner = filepath.Join(dir, "hosts.unknown"), "no-such-user-bootstrap"
+	if err := l.Symlink(Input{Copy: true}); err == nil {
+		t.Error("copying to an unknown owner succeeded")
+	}
+}
@@ -0,0 +1,16 @@
+package main
+
+// Observer is told about each link of a RunContext as it is handled, e.g. to show the progress in a user interface instead of parsing the report.
+type Observer interface {
+	// OnStart is called before the link is applied.
+	OnStart(l Link)
+	// OnResult is called with each result and the JSON key it is reported under, such as "successes", "skipped", "conflicts" or "failures". Results without a link, such as warnings, are included.
+	OnResult(kind string, r LinkResult)
+}
+
+// NopObserver is an Observer ignoring everything.
+type NopObserver struct{}
+
+func (NopObserver) OnStart(l Link) {}
+
+func (NopObserver) OnResult(kind string, r LinkResult) {}
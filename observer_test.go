@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// recorder is an Observer recording the destinations started and the kind of each result by destination.
+type recorder struct {
+	started []string
+	results map[string][]string
+}
+
+func (r *recorder) OnStart(l Link) {
+	r.started = append(r.started, l.Dest)
+}
+
+func (r *recorder) OnResult(kind string, res LinkResult) {
+	r.results[res.Link.Dest] = append(r.results[res.Link.Dest], kind)
+}
+
+func TestRunObserver(t *testing.T) {
+	dir, home := t.TempDir(), t.TempDir()
+	for _, name := range []string{"new", "linked", "conflict", "failure"} {
+		writeFile(t, filepath.Join(dir, name), "")
+	}
+	writeFile(t, filepath.Join(dir, "links.json"), `{"new": "~/new", "linked": "~/linked", "conflict": "~/conflict", "failure": "~/missing/failure"}`)
+	if err := os.Symlink(filepath.Join(dir, "linked"), filepath.Join(home, "linked")); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(home, "conflict"), "mine")
+
+	r := &recorder{results: map[string][]string{}}
+	if _, err := RunContext(context.Background(), testInput(dir, home), &bytes.Buffer{}, r); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		filepath.Join(home, "new"):                {"successes"},
+		filepath.Join(home, "linked"):             {"skipped"},
+		filepath.Join(home, "conflict"):           {"conflicts"},
+		filepath.Join(home, "missing", "failure"): {"failures"},
+	}
+	if !reflect.DeepEqual(r.results, want) {
+		t.Errorf("the observer got the results %v, want %v", r.results, want)
+	}
+	if len(r.started) != len(want) {
+		t.Errorf("the observer saw %q start, want each link once", r.started)
+	}
+	for _, dest := range r.started {
+		if _, ok := want[dest]; !ok {
+			t.Errorf("the observer saw an unknown link %v start", dest)
+		}
+	}
+}
+
+func TestRunOrder(t *testing.T) {
+	dir, home := t.TempDir(), t.TempDir()
+	for _, name := range []string{"app/config", "theme", "a", "b", "last"} {
+		writeFile(t, filepath.Join(dir, name), "")
+	}
+	// The file link inside the directory link would create the directory and block it if applied first.
+	writeFile(t, filepath.Join(dir, "links.json"), `{
+		"theme": {"dest": "~/.config/app/theme", "order": 1},
+		"app": {"dest": "~/.config/app", "order": -1, "mkdir": true},
+		"last": {"dest": "~/0-last", "order": 2},
+		"b": "~/b",
+		"a": "~/a"
+	}`)
+	r := &recorder{results: map[string][]string{}}
+	if _, err := RunContext(context.Background(), testInput(dir, home), &bytes.Buffer{}, r); err != nil {
+		t.Fatal(err)
+	}
+	// Lower orders first, the links of the same order by destination.
+	want := []string{
+		filepath.Join(home, ".config", "app"),
+		filepath.Join(home, "a"),
+		filepath.Join(home, "b"),
+		filepath.Join(home, ".config", "app", "theme"),
+		filepath.Join(home, "0-last"),
+	}
+	if !reflect.DeepEqual(r.started, want) {
+		t.Errorf("the links were applied in the order %q, want %q", r.started, want)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "app", "theme")); err != nil {
+		t.Errorf("the file link wasn't created through the directory link: %v", err)
+	}
+}
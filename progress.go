@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// progress writes a running count of the links handled to a terminal, replacing the previous count on the same line.
+type progress struct {
+	w io.Writer
+	// total is the number of links expected, or 0 if it isn't known.
+	total int
+	done  int
+}
+
+// add counts another link handled and writes the new count.
+func (p *progress) add() {
+	if p == nil {
+		return
+	}
+	p.done++
+	if p.total > 0 {
+		fmt.Fprintf(p.w, "\r%v/%v links", p.done, p.total)
+	} else {
+		fmt.Fprintf(p.w, "\r%v links", p.done)
+	}
+}
+
+// clear erases the count so the report can be written in its place.
+func (p *progress) clear() {
+	if p == nil || p.done == 0 {
+		return
+	}
+	fmt.Fprint(p.w, "\r\x1b[K")
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestProgress(t *testing.T) {
+	var buf bytes.Buffer
+	p := &progress{w: &buf, total: 3}
+	for n := 0; n < 3; n++ {
+		p.add()
+	}
+	p.clear()
+	if got, want := buf.String(), "\r1/3 links\r2/3 links\r3/3 links\r\x1b[K"; got != want {
+		t.Errorf("the progress wrote %q, want %q", got, want)
+	}
+
+	// Without a total, only the count is written.
+	buf.Reset()
+	p = &progress{w: &buf}
+	p.add()
+	if got, want := buf.String(), "\r1 links"; got != want {
+		t.Errorf("the progress wrote %q, want %q", got, want)
+	}
+
+	// A nil progress, used when it isn't shown, writes nothing.
+	var none *progress
+	none.add()
+	none.clear()
+}
+
+func TestProgressUpdates(t *testing.T) {
+	for _, total := range []int{1, 10, 1000} {
+		var buf bytes.Buffer
+		p := &progress{w: &buf, total: total}
+		for n := 0; n < total; n++ {
+			p.add()
+		}
+		updates := strings.Split(strings.TrimPrefix(buf.String(), "\r"), "\r")
+		if len(updates) != total || updates[total-1] != fmt.Sprintf("%v/%v links", total, total) {
+			t.Errorf("the progress of %v links wrote %v updates ending in %q", total, len(updates), updates[len(updates)-1])
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Stdin is where the answers to the interactive prompts are read from.
+var Stdin io.Reader = os.Stdin
+
+// confirmer returns a func asking yes or no questions on w and reading the answers from r. Anything but "y" or "yes" is a no, including an empty line or the end of r. The questions aren't asked if r is a file other than a terminal, the answer is always def instead.
+func confirmer(r io.Reader, w io.Writer, def bool) func(question string) bool {
+	if f, ok := r.(*os.File); ok && !isTerminal(f) {
+		return func(string) bool { return def }
+	}
+	br := bufio.NewReader(r)
+	return func(question string) bool {
+		fmt.Fprintf(w, "%v [y/N] ", question)
+		line, _ := br.ReadString('\n')
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "y", "yes":
+			return true
+		}
+		return false
+	}
+}
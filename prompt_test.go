@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfirmer(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []bool
+	}{
+		{"y\n", []bool{true}},
+		{"Yes\n", []bool{true}},
+		{"n\n", []bool{false}},
+		{"\n", []bool{false}},
+		{"", []bool{false}},
+		{"y\nn\nmaybe\ny", []bool{true, false, false, true}},
+	}
+	for _, test := range tests {
+		var w bytes.Buffer
+		confirm := confirmer(strings.NewReader(test.input), &w, false)
+		for n, want := range test.want {
+			if got := confirm("overwrite x?"); got != want {
+				t.Errorf("answer %v to %q = %v, want %v", n, test.input, got, want)
+			}
+		}
+		if !strings.HasPrefix(w.String(), "overwrite x? [y/N] ") {
+			t.Errorf("the question asked was %q", w.String())
+		}
+	}
+}
+
+func TestConfirmerNotTerminal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "answers")
+	writeFile(t, path, "y\n")
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, def := range []bool{false, true} {
+		var w bytes.Buffer
+		if got := confirmer(f, &w, def)("overwrite x?"); got != def {
+			t.Errorf("the answer without a terminal is %v, want %v", got, def)
+		}
+		if w.Len() > 0 {
+			t.Errorf("asked %q without a terminal", w.String())
+		}
+	}
+}
+
+func TestRunInteractive(t *testing.T) {
+	defer func(r io.Reader) { Stdin = r }(Stdin)
+	for answer, replaced := range map[string]bool{"y\n": true, "n\n": false} {
+		dir, home := t.TempDir(), t.TempDir()
+		writeFile(t, filepath.Join(dir, "vimrc"), "")
+		writeFile(t, filepath.Join(dir, "links.json"), `{"vimrc": "~/.vimrc"}`)
+		writeFile(t, filepath.Join(home, ".vimrc"), "mine")
+		Stdin = strings.NewReader(answer)
+		i := testInput(dir, home)
+		i.Interactive = true
+		run(t, i)
+		info, err := os.Lstat(filepath.Join(home, ".vimrc"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.Mode()&os.ModeSymlink != 0; got != replaced {
+			t.Errorf("answering %q replaced the file: %v, want %v", answer, got, replaced)
+		}
+	}
+}
@@ -0,0 +1,762 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultTrailer is the reminder printed after making changes if the Input doesn't say otherwise.
+const DefaultTrailer = "Changes will take effect after sourcing your .*shrc"
+
+// errorHeaders are the messages headers still printed in quiet mode.
+var errorHeaders = map[string]bool{
+	"Errors":                true,
+	"Failures":              true,
+	"Conflicts":             true,
+	"Directory failures":    true,
+	"Validation errors":     true,
+	"Missing":               true,
+	"Hook failures":         true,
+	"Verification failures": true,
+}
+
+// Run bootstraps the dotfiles directory as described by i, writing the report to w. Linking stops on an interrupt. The returned Summary counts the results. An error is returned if the directory is missing, unless AllowMissing is set, can't be searched or the previous links can't be loaded.
+func Run(i Input, w io.Writer) (Summary, error) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	return RunContext(ctx, i, w, nil)
+}
+
+// RunContext is like Run but stops linking once ctx is done instead of on an interrupt, telling o about each link handled if it isn't nil. o is called from a single goroutine at a time.
+func RunContext(ctx context.Context, i Input, w io.Writer, o Observer) (Summary, error) {
+	if o == nil {
+		o = NopObserver{}
+	}
+	if i.PrintConfig {
+		return Summary{}, printConfig(i, w)
+	}
+
+	// Create the Bootstrap, its logger being used from here on.
+	b := i.bootstrap()
+
+	// The paths may refer to the variables of the env files.
+	err := loadEnvFiles(i.EnvFile, b.logger())
+	if err != nil {
+		return Summary{}, err
+	}
+
+	if i.Doctor {
+		return Summary{}, doctor(i, w)
+	}
+
+	// apply is false for a dry run, in which nothing on disk may change.
+	apply := !i.Dry
+
+	// Populate the Bootstrap DotDirs
+	// Only run the hooks when the links are created.
+	b.Hooks = apply && !i.Status && !i.Unlink
+	if i.DirsFrom != "" {
+		// Use exactly the listed directories.
+		err := b.ReadDirs(i.DirsFrom)
+		if err != nil {
+			return Summary{}, err
+		}
+	} else if i.Dir == "-" {
+		// Read the links from stdin instead of searching for links files.
+		data, err := io.ReadAll(Stdin)
+		if err != nil {
+			return Summary{}, err
+		}
+		wd, err := os.Getwd()
+		if err != nil {
+			return Summary{}, err
+		}
+		b.DotDirs = []DotDir{{Path: wd, Data: data, Profiles: b.Profiles, Root: b.Root, AllowedEnv: b.AllowedEnv, ExpandDirs: b.ExpandDirs, MergeDirs: b.MergeDirs, Home: b.Home, Logger: b.Logger}}
+	} else {
+		// Search each of the comma separated directories
+		for _, root := range strings.Split(i.Dir, ",") {
+			dir, err := filepath.Abs(root)
+			if err != nil {
+				return Summary{}, err
+			}
+			// A missing directory would otherwise just have nothing to link.
+			if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+				if i.AllowMissing {
+					b.logger().Info("Skipping the missing directory", "dir", dir)
+					b.Unsearched = append(b.Unsearched, dir)
+					continue
+				}
+				if err == nil {
+					return Summary{}, fmt.Errorf("%v is not a directory, check $%v or -dir", dir, DotEnv)
+				}
+				return Summary{}, fmt.Errorf("the dotfiles directory %v is missing, check $%v or -dir: %w", dir, DotEnv, err)
+			}
+			n := len(b.DotDirs)
+			if i.Mirror {
+				b.AddMirror(dir)
+			} else if err = b.Walk(dir); err != nil {
+				return Summary{}, err
+			}
+
+			// Keep only the DotDirs matching an -only pattern and none of the -exclude patterns.
+			if len(i.Only) > 0 || len(i.Exclude) > 0 {
+				var dotDirs []DotDir
+				for _, dotDir := range b.DotDirs[n:] {
+					rel, _ := filepath.Rel(dir, dotDir.Path)
+					if len(i.Only) > 0 && !ignored(i.Only, rel) {
+						b.Excluded = append(b.Excluded, dotDir)
+						continue
+					}
+					if ignored(i.Exclude, rel) {
+						b.logger().Debug("Excluding a directory", "dir", dotDir.Path)
+						b.Excluded = append(b.Excluded, dotDir)
+						continue
+					}
+					dotDirs = append(dotDirs, dotDir)
+				}
+				b.DotDirs = append(b.DotDirs[:n], dotDirs...)
+			}
+		}
+	}
+
+	if i.List {
+		return Summary{}, list(b, i.JSON, w)
+	}
+
+	// Load the links created by previous runs, only if something uses them.
+	state := newState()
+	if i.usesState() {
+		state, err = LoadState(i.statePath())
+		if err != nil {
+			return Summary{}, err
+		}
+	}
+
+	// Create the needed chan
+	linkResults := make(chan LinkResult)
+
+	wg := new(sync.WaitGroup)
+	messages := map[string][]message{}
+	// The results grouped by their JSON key, used instead of the messages in JSON mode.
+	results := map[string][]LinkResult{}
+	// report adds the result under the JSON key and tells the Observer about it.
+	report := func(key string, r LinkResult) {
+		results[key] = append(results[key], r)
+		o.OnResult(key, r)
+	}
+
+	// Report the paths that couldn't be searched.
+	for _, err := range b.Warnings {
+		a := messages["Warnings"]
+		messages["Warnings"] = append(a, message{"", err.Error()})
+		report("warnings", LinkResult{Err: err})
+	}
+
+	// Validate all the links before creating any of them.
+	valid := true
+	total := 0
+	// The validation errors, so a links file that can't be read isn't reported again when linking.
+	invalid := map[string]bool{}
+	if !i.Status && !i.Unlink {
+		var errs []error
+		total, errs = b.validate()
+		for _, err := range errs {
+			valid = false
+			invalid[err.Error()] = true
+			a := messages["Validation errors"]
+			messages["Validation errors"] = append(a, message{"", err.Error()})
+			report("validation_errors", LinkResult{Err: err})
+		}
+	}
+
+	// Only show the progress to someone watching it.
+	var p *progress
+	if i.Progress && !i.JSON && isTerminal(os.Stderr) {
+		p = &progress{w: os.Stderr, total: total}
+	}
+
+	// Nothing changes in a status run, so the destination directories only need reading once.
+	var cache *statCache
+	if i.Status {
+		cache = newStatCache()
+	}
+
+	// Without a terminal to ask on, only overwrite files if forced.
+	confirm := confirmer(Stdin, os.Stderr, i.Force)
+
+	// handle applies a single result. It holds mu while using anything shared, so it can be called by several workers at once.
+	mu := new(sync.Mutex)
+	handle := func(r LinkResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		p.add()
+		if r.Err == ErrBlocked {
+			// Add the link skipped because of a failing pre hook to the messages map.
+			a := messages["Blocked"]
+			messages["Blocked"] = append(a, message{r.Link.Dest, r.Link.String()})
+			report("blocked", r)
+			return
+		}
+		if herr, ok := r.Err.(*HookError); ok {
+			// Add the failing pre hook to the messages map.
+			a := messages["Hook failures"]
+			messages["Hook failures"] = append(a, message{"", herr.Error()})
+			report("failures", r)
+			return
+		}
+		if r.Err != nil && invalid[r.Err.Error()] {
+			return
+		}
+		if r.Err != nil && r.Err != ErrDuplicate {
+			// Add the bootstrap error to the messages map.
+			a := messages["Errors"]
+			messages["Errors"] = append(a, message{"", r.Err.Error()})
+			report("errors", r)
+			return
+		}
+		link := r.Link
+		o.OnStart(link)
+
+		if r.Err == ErrDuplicate {
+			// Add the later duplicate of a link to the messages map.
+			a := messages["Skipped"]
+			messages["Skipped"] = append(a, message{link.Dest, fmt.Sprintf("%v: %v", ErrDuplicate, link)})
+			report("skipped", LinkResult{Link: link, Err: ErrDuplicate})
+			return
+		}
+
+		if i.Status {
+			// Group the link by the state of its destination.
+			state := link.state(cache).String()
+			messages[state] = append(messages[state], message{link.Dest, link.String()})
+			key := strings.Replace(strings.ToLower(state), " ", "_", -1)
+			report(key, LinkResult{Link: link})
+			return
+		}
+
+		if err := link.unmet(); err != nil && !i.Unlink {
+			// Add the link requiring missing commands to the messages map.
+			a := messages["Skipped"]
+			messages["Skipped"] = append(a, message{link.Dest, fmt.Sprintf("%v: %v", err, link)})
+			report("skipped", LinkResult{Link: link, Err: err})
+			return
+		}
+
+		if !apply && !i.Unlink {
+			// Add the current and desired destinations to the messages map, grouped by what linking would do.
+			b.logger().Info(link.cmd(i))
+			current, desired := link.Diff()
+			preview := link.Preview(i)
+			messages[preview] = append(messages[preview], message{link.Dest, fmt.Sprintf("%v: %v => %v", link.Dest, current, desired)})
+			report(strings.ToLower(preview), LinkResult{Link: link})
+			return
+		}
+
+		if i.Unlink {
+			// Remove the symlink if it points at the source.
+			err := link.Unlink(apply)
+			if err == ErrNotLinked {
+				// Add the link not owned by bootstrap to the messages map.
+				a := messages["Skipped"]
+				messages["Skipped"] = append(a, message{link.Dest, fmt.Sprintf("%v: %v", err, link)})
+				report("skipped", LinkResult{Link: link, Err: err})
+				return
+			}
+			if err != nil {
+				// Add the Unlink error to the messages map.
+				a := messages["Failures"]
+				messages["Failures"] = append(a, message{link.Dest, fmt.Sprintf("%v: %v", err, link)})
+				report("failures", LinkResult{Link: link, Err: err})
+				return
+			}
+			if !apply {
+				// Add the rm command to the messages map.
+				a := messages["Commands"]
+				messages["Commands"] = append(a, message{link.Dest, fmt.Sprintf("rm %v", link.Dest)})
+				report("commands", LinkResult{Link: link})
+				return
+			}
+			state.forget(link.Dest)
+			// Add the removed Link string to the messages map.
+			a := messages["Removed"]
+			messages["Removed"] = append(a, message{link.Dest, link.String()})
+			report("removed", LinkResult{Link: link})
+			return
+		}
+
+		if i.Since && state.unchanged(link) && link.applied(link.input(i).Copy) {
+			// Add the link with an unmodified source to the messages map.
+			a := messages["Skipped"]
+			messages["Skipped"] = append(a, message{link.Dest, fmt.Sprintf("%v: %v", ErrUnchanged, link)})
+			report("skipped", LinkResult{Link: link, Err: ErrUnchanged})
+			return
+		}
+
+		// Write the symlink. Use the user specified force and backup flags,
+		// asking before replacing each conflicting file in interactive mode.
+		input := i
+		if i.Interactive {
+			input.Force = false
+			input.ForceDir = false
+		}
+		if i.Sync && state.owns(link.Dest) {
+			// Replace a link made by a previous run that has drifted, but nothing bootstrap didn't make.
+			input.Force = true
+		}
+		// Only the linking itself runs in parallel.
+		mu.Unlock()
+		err := link.Symlink(input)
+		if _, ok := err.(*ErrConflict); ok && i.Interactive && confirm(fmt.Sprintf("overwrite %v?", link.Dest)) {
+			// Directories are still only replaced with -force-dir.
+			input.Force = true
+			input.ForceDir = i.ForceDir
+			err = link.Symlink(input)
+		}
+		mu.Lock()
+		if err == ErrLinked {
+			state.link(link)
+			state.touch(link)
+			// Add the already existing link to the messages map.
+			a := messages["Skipped"]
+			messages["Skipped"] = append(a, message{link.Dest, link.String()})
+			report("skipped", LinkResult{Link: link, Err: err})
+			return
+		}
+		if err == ErrCopied {
+			state.touch(link)
+			// Add the unchanged copy to the messages map.
+			a := messages["Skipped"]
+			messages["Skipped"] = append(a, message{link.Dest, fmt.Sprintf("%v: %v", err, link)})
+			report("skipped", LinkResult{Link: link, Err: err})
+			return
+		}
+		if cerr, ok := err.(*ErrConflict); ok {
+			// Add the conflicting file to the messages map.
+			a := messages["Conflicts"]
+			flag := "-force"
+			if cerr.Info.IsDir() {
+				flag = "-force-dir"
+			}
+			if i.SymlinksOnly {
+				// Real files are never replaced in this mode, so only moving them by hand helps.
+				messages["Conflicts"] = append(a, message{link.Dest, fmt.Sprintf("%v, move it aside to replace it: %v", cerr, link)})
+			} else {
+				messages["Conflicts"] = append(a, message{link.Dest, fmt.Sprintf("%v, use %v to replace it or %v -backup to move it aside: %v", cerr, flag, flag, link)})
+			}
+			report("conflicts", LinkResult{Link: link, Err: err})
+			return
+		}
+		if berr, ok := err.(*BrokenLinkError); ok {
+			// Add the broken link to something else to the messages map.
+			a := messages["Conflicts"]
+			messages["Conflicts"] = append(a, message{link.Dest, fmt.Sprintf("%v, use -force to replace it: %v", berr, link)})
+			report("conflicts", LinkResult{Link: link, Err: err})
+			return
+		}
+		if serr, ok := err.(*MissingSourceError); ok {
+			// Add the link to a missing source to the messages map.
+			a := messages["Missing"]
+			messages["Missing"] = append(a, message{link.Dest, fmt.Sprintf("%v: %v", serr, link)})
+			report("missing", LinkResult{Link: link, Err: err})
+			return
+		}
+		if merr, ok := err.(*MkdirError); ok {
+			// Add the directory error to the messages map.
+			a := messages["Directory failures"]
+			messages["Directory failures"] = append(a, message{link.Dest, fmt.Sprintf("%v: %v", merr, link)})
+			report("failures", LinkResult{Link: link, Err: err})
+			return
+		}
+		if link.CreatedDir != "" {
+			// Add the created directory to the messages map.
+			a := messages["Directories"]
+			messages["Directories"] = append(a, message{link.CreatedDir, link.CreatedDir})
+		}
+		if err != nil {
+			// Add the Symlink error to the messages map, whatever its type, keeping the whole error for the results.
+			a := messages["Failures"]
+			messages["Failures"] = append(a, message{link.Dest, fmt.Sprintf("%v: %v", describeError(err), link)})
+			report("failures", LinkResult{Link: link, Err: err})
+			return
+		}
+		if link.Hardlinked {
+			state.touch(link)
+			// Add the hard linked Link string to the messages map.
+			a := messages["Hard linked"]
+			messages["Hard linked"] = append(a, message{link.Dest, link.String()})
+			report("hardlinked", LinkResult{Link: link})
+			return
+		}
+		if link.Copied {
+			state.touch(link)
+			// Add the copied Link string to the messages map.
+			a := messages["Copied"]
+			messages["Copied"] = append(a, message{link.Dest, link.String()})
+			report("copied", LinkResult{Link: link})
+			return
+		}
+		state.link(link)
+		state.touch(link)
+		if link.Merged {
+			// Add the link merged into an existing directory to the messages map.
+			a := messages["Merged"]
+			messages["Merged"] = append(a, message{link.Dest, link.String()})
+			report("merged", LinkResult{Link: link})
+			return
+		}
+		// Add the newly created Link string to the messages map.
+		a := messages["Successes"]
+		messages["Successes"] = append(a, message{link.Dest, link.String()})
+		report("successes", LinkResult{Link: link})
+	}
+
+	// Spawn the workers creating the desired links, a single one when asking about each conflict.
+	workers := i.ParallelLinks
+	if workers <= 0 || i.Interactive {
+		workers = 1
+	}
+	// applied counts down the links handled, so the next order can wait for them.
+	applied := new(sync.WaitGroup)
+	wg.Add(workers)
+	for n := 0; n < workers; n++ {
+		go func() {
+			defer wg.Done()
+			for r := range linkResults {
+				handle(r)
+				applied.Done()
+			}
+		}()
+	}
+
+	// Remove the links dropped from the links files.
+	if (i.Prune || i.Sync) && !i.Status {
+		pruned, err := b.Prune(state, apply)
+		if err != nil {
+			messages["Errors"] = append(messages["Errors"], message{"", err.Error()})
+			report("errors", LinkResult{Err: err})
+		}
+		for _, r := range pruned {
+			switch {
+			case r.Err == nil && !apply:
+				messages["Commands"] = append(messages["Commands"], message{r.Link.Dest, fmt.Sprintf("rm %v", r.Link.Dest)})
+				report("commands", r)
+			case r.Err == nil:
+				messages["Pruned"] = append(messages["Pruned"], message{r.Link.Dest, r.Link.String()})
+				report("pruned", r)
+			case r.Err == ErrNotLinked:
+				messages["Skipped"] = append(messages["Skipped"], message{r.Link.Dest, fmt.Sprintf("%v: %v", r.Err, r.Link)})
+				report("skipped", r)
+			default:
+				messages["Failures"] = append(messages["Failures"], message{r.Link.Dest, fmt.Sprintf("%v: %v", r.Err, r.Link)})
+				report("failures", r)
+			}
+		}
+	}
+
+	// Kick off the links method. Nothing is linked if validation failed, unless forced.
+	proceed := valid || i.Force || i.Dry
+	if proceed {
+		// Read all the links before applying any, so they can be applied lowest order first, then by destination, then in the order of the DotDirs.
+		pending := make(chan LinkResult)
+		go func() {
+			b.LinkContext(ctx, pending)
+			close(pending)
+		}()
+		var ordered []LinkResult
+		for r := range pending {
+			ordered = append(ordered, r)
+		}
+		sort.SliceStable(ordered, func(m, n int) bool {
+			if ordered[m].Link.Order != ordered[n].Link.Order {
+				return ordered[m].Link.Order < ordered[n].Link.Order
+			}
+			if ordered[m].Link.Dest != ordered[n].Link.Dest {
+				return ordered[m].Link.Dest < ordered[n].Link.Dest
+			}
+			return ordered[m].dotDir < ordered[n].dotDir
+		})
+		// Apply the first of the links to the same destination, so links produced by more than one DotDir are only applied once.
+		seen := map[string]bool{}
+		for n, r := range ordered {
+			if r.Err != nil {
+				continue
+			}
+			if seen[r.Link.Dest] {
+				ordered[n].Err = ErrDuplicate
+			}
+			seen[r.Link.Dest] = true
+		}
+		// Only start on an order once the lower ones are done, applying the links of the same order at once.
+		for len(ordered) > 0 && ctx.Err() == nil {
+			n := 1
+			for n < len(ordered) && ordered[n].Link.Order == ordered[0].Link.Order {
+				n++
+			}
+			applied.Add(n)
+			for _, r := range ordered[:n] {
+				linkResults <- r
+			}
+			applied.Wait()
+			ordered = ordered[n:]
+		}
+	}
+
+	// Links only returns once all the links or errors
+	// have been added to the chan. We can safley
+	// close the chan.
+	close(linkResults)
+	// Wait for all the symlinks to be created.
+	wg.Wait()
+	p.clear()
+	if ctx.Err() != nil {
+		messages["Errors"] = append(messages["Errors"], message{"", "interrupted"})
+		report("errors", LinkResult{Err: ctx.Err()})
+	}
+	// Run the post hooks of each DotDir once its links are in place.
+	if proceed && b.Hooks && ctx.Err() == nil {
+		for _, err := range b.RunPostHooks() {
+			messages["Hook failures"] = append(messages["Hook failures"], message{"", err.Error()})
+			report("failures", LinkResult{Err: err})
+		}
+	}
+	// Show what the hooks printed, that of the failing ones being in their HookError.
+	for _, r := range b.HookRuns {
+		if r.Output != "" {
+			messages["Hook output"] = append(messages["Hook output"], message{"", r.String()})
+		}
+	}
+	// Record the created links for future runs.
+	if apply && !i.Status && i.usesState() {
+		err := state.Save(i.statePath())
+		if err != nil {
+			messages["Errors"] = append(messages["Errors"], message{"", err.Error()})
+			report("errors", LinkResult{Err: err})
+		}
+	}
+	// Check every link applied is really in place, in case the filesystem didn't do as it was told.
+	var verr error
+	if i.Verify && apply && !i.Status && !i.Unlink {
+		for _, key := range []string{"successes", "merged", "copied", "hardlinked", "skipped"} {
+			for _, r := range results[key] {
+				if _, ok := r.Err.(*UnmetError); ok || r.Err == ErrDuplicate {
+					continue
+				}
+				copied := key == "copied" || key == "hardlinked" || key == "skipped" && (r.Err == ErrCopied || r.Err == ErrUnchanged && r.Link.input(i).Copy)
+				if err := r.Link.Verify(copied); err != nil {
+					a := messages["Verification failures"]
+					messages["Verification failures"] = append(a, message{r.Link.Dest, fmt.Sprintf("%v: %v", err, r.Link)})
+					report("verification_failures", LinkResult{Link: r.Link, Err: err})
+				}
+			}
+		}
+		if n := len(messages["Verification failures"]); n > 0 {
+			verr = fmt.Errorf("%v links failed verification", n)
+		}
+	}
+	// Sort the results and messages by destination, as they arrive in no particular order from the concurrent reads.
+	for _, r := range results {
+		sort.SliceStable(r, func(a, b int) bool { return r[a].Link.Dest < r[b].Link.Dest })
+	}
+	for _, msgs := range messages {
+		sort.SliceStable(msgs, func(a, b int) bool {
+			if msgs[a].dest != msgs[b].dest {
+				return msgs[a].dest < msgs[b].dest
+			}
+			return msgs[a].text < msgs[b].text
+		})
+	}
+	if i.JSON {
+		// Print out all the results, always including the main groups.
+		out := map[string][]LinkResult{}
+		for _, key := range []string{"successes", "failures", "skipped", "conflicts", "errors"} {
+			out[key] = []LinkResult{}
+		}
+		for key, r := range results {
+			out[key] = r
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(out); err != nil {
+			return newSummary(messages), err
+		}
+		return newSummary(messages), verr
+	}
+	if i.Quiet {
+		// Only keep the messages describing errors.
+		for header := range messages {
+			if !errorHeaders[header] {
+				delete(messages, header)
+			}
+		}
+	}
+	// Print out all the messages, colored when writing to a terminal
+	f, ok := w.(*os.File)
+	color := !i.NoColor && ok && isTerminal(f)
+	var headers []string
+	for header := range messages {
+		headers = append(headers, header)
+	}
+	sort.Strings(headers)
+	for _, header := range headers {
+		var msgs []string
+		for _, m := range messages[header] {
+			msgs = append(msgs, m.text)
+		}
+		if len(messages) > 1 {
+			if color {
+				fmt.Fprintln(w, colorize(colorBold, header+":"))
+			} else {
+				fmt.Fprintln(w, header+":")
+			}
+		}
+		if color {
+			fmt.Fprintln(w, colorize(headerColors[header], strings.Join(msgs, "\n")))
+		} else {
+			fmt.Fprintln(w, strings.Join(msgs, "\n"))
+		}
+	}
+	// Remind whoever is watching to pick up the changes, without mixing it into the report.
+	if len(messages) > 0 && proceed && !i.Status && !i.Quiet && i.Trailer != "" && isTerminal(os.Stderr) {
+		fmt.Fprintln(os.Stderr, i.Trailer)
+	}
+	return newSummary(messages), verr
+}
+
+// message is a line of the report about the link to dest, which is empty for the errors not about a link. The lines are sorted by dest.
+type message struct {
+	dest string
+	text string
+}
+
+// Summary counts the results of a Run.
+type Summary struct {
+	Created   int
+	Skipped   int
+	Failed    int
+	Copied    int
+	Merged    int
+	Removed   int
+	Pruned    int
+	Conflicts int
+	Errors    int
+}
+
+// describeError returns the message of an error failing a link. The paths of an *os.LinkError are left out as they are those of the link, while the operation and cause are kept, e.g. "symlink: file exists".
+func describeError(err error) string {
+	var lerr *os.LinkError
+	if errors.As(err, &lerr) {
+		return fmt.Sprintf("%v: %v", lerr.Op, lerr.Err)
+	}
+	return err.Error()
+}
+
+// bootstrap returns a Bootstrap with the options of i, without any DotDirs.
+func (i Input) bootstrap() *Bootstrap {
+	b := NewBootstrap(WithLogger(slog.New(logHandler{})))
+	b.Hidden = i.All
+	b.Profiles = i.Profile
+	b.Timeout = i.Timeout
+	b.Root = i.Root
+	b.AllowedEnv = i.AllowedEnv
+	b.ExpandDirs = !i.DirLinks
+	b.MergeDirs = i.MergeDirs
+	b.Home = i.HomeDir
+	b.LinkFiles = i.LinkFile
+	b.LinkFileGlob = i.LinkFileGlob
+	b.FollowSymlinks = i.Follow
+	b.MaxDepth = i.MaxDepth
+	b.Concurrency = i.ParallelDirs
+	return b
+}
+
+// newSummary counts the messages under each header.
+func newSummary(messages map[string][]message) Summary {
+	count := func(headers ...string) int {
+		n := 0
+		for _, header := range headers {
+			n += len(messages[header])
+		}
+		return n
+	}
+	return Summary{
+		Created:   count("Successes"),
+		Skipped:   count("Skipped"),
+		Failed:    count("Failures", "Directory failures", "Missing", "Hook failures", "Verification failures"),
+		Copied:    count("Copied", "Hard linked"),
+		Merged:    count("Merged"),
+		Removed:   count("Removed"),
+		Pruned:    count("Pruned"),
+		Conflicts: count("Conflicts"),
+		Errors:    count("Errors", "Validation errors"),
+	}
+}
+
+// String returns a line with the counts, e.g. "5 created, 2 skipped, 1 failed". The other counts are only included if not zero.
+func (s Summary) String() string {
+	parts := []string{
+		fmt.Sprintf("%v created", s.Created),
+		fmt.Sprintf("%v skipped", s.Skipped),
+		fmt.Sprintf("%v failed", s.Failed),
+	}
+	for _, c := range []struct {
+		count int
+		label string
+	}{
+		{s.Copied, "copied"},
+		{s.Merged, "merged"},
+		{s.Removed, "removed"},
+		{s.Pruned, "pruned"},
+		{s.Conflicts, "conflicts"},
+		{s.Errors, "errors"},
+	} {
+		if c.count > 0 {
+			parts = append(parts, fmt.Sprintf("%v %v", c.count, c.label))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// list writes each of the DotDirs found, with its links files and the number of links they hold.
+func list(b *Bootstrap, asJSON bool, w io.Writer) error {
+	type dotDir struct {
+		Path      string   `json:"path"`
+		LinkFiles []string `json:"link_files"`
+		Links     int      `json:"links"`
+		Error     string   `json:"error,omitempty"`
+	}
+	dotDirs := []dotDir{}
+	for _, d := range b.DotDirs {
+		links, err := b.readLinks(d)
+		entry := dotDir{Path: d.Path, LinkFiles: d.LinkFiles, Links: len(links)}
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		dotDirs = append(dotDirs, entry)
+	}
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(dotDirs)
+	}
+	for _, d := range dotDirs {
+		if d.Error != "" {
+			fmt.Fprintf(w, "%v: %v\n", d.Path, d.Error)
+		} else {
+			fmt.Fprintf(w, "%v: %v links\n", d.Path, d.Links)
+		}
+		for _, linkFile := range d.LinkFiles {
+			fmt.Fprintf(w, "  %v\n", linkFile)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,745 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testInput returns the Input of a run over the dotfiles in dir with home as the home directory, with the defaults set by LoadConfig.
+func testInput(dir, home string) Input {
+	return Input{
+		Dir:           dir,
+		HomeDir:       home,
+		Timeout:       DefaultTimeout,
+		DirLinks:      true,
+		RetryDelay:    DefaultRetryDelay,
+		ParallelLinks: 1,
+		MaxDepth:      -1,
+	}
+}
+
+// run runs i and returns the report, failing t if the run fails.
+func run(t *testing.T, i Input) (Summary, string) {
+	t.Helper()
+	var out bytes.Buffer
+	summary, err := Run(i, &out)
+	if err != nil {
+		t.Fatalf("Run failed: %v\n%v", err, out.String())
+	}
+	return summary, out.String()
+}
+
+func TestRunStateOnlyWhenUsed(t *testing.T) {
+	dir, home := t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(dir, "vimrc"), "")
+	writeFile(t, filepath.Join(dir, "links.json"), `{"vimrc": "~/.vimrc"}`)
+	statePath := filepath.Join(home, StateFile)
+
+	// A plain run neither reads nor writes the state file, so a corrupt one is left alone.
+	run(t, testInput(dir, home))
+	if _, err := os.Stat(statePath); !os.IsNotExist(err) {
+		t.Fatalf("a plain run wrote %v", statePath)
+	}
+	writeFile(t, statePath, "{corrupt")
+	i := testInput(dir, home)
+	i.Force = true
+	run(t, i)
+
+	// Pruning needs the state, so it fails on a corrupt one.
+	i.Prune = true
+	if _, err := Run(i, &bytes.Buffer{}); err == nil {
+		t.Error("pruning with a corrupt state file succeeded")
+	}
+
+	// Pruning records the links.
+	os.Remove(statePath)
+	run(t, i)
+	s, err := LoadState(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src := s.Links[filepath.Join(home, ".vimrc")]; src != filepath.Join(dir, "vimrc") {
+		t.Errorf("the state links %v to %q, want %v", filepath.Join(home, ".vimrc"), src, filepath.Join(dir, "vimrc"))
+	}
+
+	// So does a run setting the state file.
+	i = testInput(dir, home)
+	i.StateFile = filepath.Join(t.TempDir(), "state.json")
+	run(t, i)
+	if _, err := os.Stat(i.StateFile); err != nil {
+		t.Errorf("a run setting the state file didn't write it: %v", err)
+	}
+}
+
+func TestRunPrune(t *testing.T) {
+	dir, home := t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(dir, "vimrc"), "")
+	writeFile(t, filepath.Join(dir, "zshrc"), "")
+	writeFile(t, filepath.Join(dir, "links.json"), `{"vimrc": "~/.vimrc", "zshrc": "~/.zshrc"}`)
+	i := testInput(dir, home)
+	i.Prune = true
+	run(t, i)
+
+	// Dropping zshrc from the links file prunes its link, but not a file replacing the other.
+	writeFile(t, filepath.Join(dir, "links.json"), `{}`)
+	os.Remove(filepath.Join(home, ".vimrc"))
+	writeFile(t, filepath.Join(home, ".vimrc"), "mine")
+	summary, _ := run(t, i)
+	if summary.Pruned != 1 {
+		t.Errorf("pruned %v links, want 1", summary.Pruned)
+	}
+	if _, err := os.Lstat(filepath.Join(home, ".zshrc")); !os.IsNotExist(err) {
+		t.Errorf("the dropped link is still there: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(home, ".vimrc")); err != nil || string(data) != "mine" {
+		t.Errorf("the file replacing a link was changed: %q, %v", data, err)
+	}
+}
+
+func TestRunPruneFiltered(t *testing.T) {
+	dir, missing, home := t.TempDir(), t.TempDir(), t.TempDir()
+	for _, d := range []string{filepath.Join(dir, "vim"), filepath.Join(dir, "zsh"), missing} {
+		writeFile(t, filepath.Join(d, "rc"), "")
+		writeFile(t, filepath.Join(d, "links.json"), `{"rc": "~/.`+filepath.Base(d)+`rc"}`)
+	}
+	i := testInput(dir+","+missing, home)
+	i.Prune = true
+	run(t, i)
+	links := snapshot(t, home)
+
+	// Links from the directories left out of the run are still in their links files.
+	os.RemoveAll(missing)
+	filters := map[string]func(*Input){
+		"only":    func(i *Input) { i.Only = []string{"vim"} },
+		"exclude": func(i *Input) { i.Exclude = []string{"zsh"} },
+		"sync":    func(i *Input) { i.Sync, i.Only = true, []string{"vim"} },
+	}
+	for name, set := range filters {
+		i := testInput(dir+","+missing, home)
+		i.Prune, i.AllowMissing = true, true
+		set(&i)
+		summary, _ := run(t, i)
+		if summary.Pruned != 0 {
+			t.Errorf("-%v pruned %v links, want none", name, summary.Pruned)
+		}
+		if got := snapshot(t, home); !reflect.DeepEqual(got, links) {
+			t.Errorf("-%v changed %v from %q to %q", name, home, links, got)
+		}
+	}
+}
+
+func TestRunContextCancelled(t *testing.T) {
+	dir, home := t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(dir, "vimrc"), "")
+	writeFile(t, filepath.Join(dir, "links.json"), `{"vimrc": "~/.vimrc"}`)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var out bytes.Buffer
+	summary, err := RunContext(ctx, testInput(dir, home), &out, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Errors != 1 || !strings.Contains(out.String(), "interrupted") {
+		t.Errorf("a cancelled run reported %+v:\n%v", summary, out.String())
+	}
+	if _, err := os.Lstat(filepath.Join(home, ".vimrc")); !os.IsNotExist(err) {
+		t.Errorf("a cancelled run linked %v", filepath.Join(home, ".vimrc"))
+	}
+}
+
+// snapshot describes everything under dir: the contents of the files, the targets of the symlinks and the directories.
+func snapshot(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	m := map[string]string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			m[path] = "link to " + target
+			return err
+		case info.IsDir():
+			m[path] = "directory"
+		default:
+			data, err := os.ReadFile(path)
+			m[path] = "file " + string(data)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestRunDryChangesNothing(t *testing.T) {
+	dir, home := t.TempDir(), t.TempDir()
+	for _, name := range []string{"vimrc", "zshrc", "gitconfig", "tmux.conf", "nvim/init.vim"} {
+		writeFile(t, filepath.Join(dir, name), name)
+	}
+	writeFile(t, filepath.Join(dir, "links.json"), `{
+	"vimrc": "~/.vimrc",
+	"zshrc": "~/.zshrc",
+	"gitconfig": "~/.gitconfig",
+	"tmux.conf": "~/.tmux.conf",
+	"nvim": "~/.config/nvim"
+}`)
+	// One of each: linked, a file in the way, a link elsewhere, a directory in the way and missing.
+	if err := os.Symlink(filepath.Join(dir, "vimrc"), filepath.Join(home, ".vimrc")); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(home, ".zshrc"), "mine")
+	if err := os.Symlink(filepath.Join(home, ".zshrc"), filepath.Join(home, ".gitconfig")); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(home, ".config", "nvim", "init.vim"), "mine")
+	// A link recorded by an earlier run and since dropped from the links file.
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	if err := os.Symlink(filepath.Join(dir, "old"), filepath.Join(home, ".old")); err != nil {
+		t.Fatal(err)
+	}
+	s := newState()
+	s.link(Link{Src: filepath.Join(dir, "old"), Dest: filepath.Join(home, ".old")})
+	if err := s.Save(statePath); err != nil {
+		t.Fatal(err)
+	}
+	before, state := snapshot(t, home), snapshot(t, filepath.Dir(statePath))
+
+	options := map[string]func(*Input){
+		"plain":     func(i *Input) {},
+		"force":     func(i *Input) { i.Force, i.ForceDir = true, true },
+		"backup":    func(i *Input) { i.Force, i.ForceDir, i.Backup = true, true, true },
+		"mkdir":     func(i *Input) { i.MkdirAll = true },
+		"copy":      func(i *Input) { i.Copy, i.Force = true, true },
+		"unlink":    func(i *Input) { i.Unlink = true },
+		"status":    func(i *Input) { i.Status = true },
+		"prune":     func(i *Input) { i.Prune = true },
+		"sync":      func(i *Input) { i.Sync, i.Force = true, true },
+		"since":     func(i *Input) { i.Since = true },
+		"relative":  func(i *Input) { i.Relative, i.Force = true, true },
+		"symlinks":  func(i *Input) { i.SymlinksOnly = true },
+		"mergedirs": func(i *Input) { i.MergeDirs = true },
+	}
+	for name, set := range options {
+		i := testInput(dir, home)
+		i.Dry = true
+		i.StateFile = statePath
+		set(&i)
+		run(t, i)
+		if after := snapshot(t, home); !reflect.DeepEqual(after, before) {
+			t.Errorf("a dry %v run changed %v from %q to %q", name, home, before, after)
+		}
+		if after := snapshot(t, filepath.Dir(statePath)); !reflect.DeepEqual(after, state) {
+			t.Errorf("a dry %v run changed the state file", name)
+		}
+	}
+}
+
+// listDirs runs i in list mode and returns the paths of the directories found, relative to i.Dir.
+func listDirs(t *testing.T, i Input) []string {
+	t.Helper()
+	i.List, i.JSON = true, true
+	_, out := run(t, i)
+	var dotDirs []struct{ Path string }
+	err := json.Unmarshal([]byte(out), &dotDirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirs := []string{}
+	for _, d := range dotDirs {
+		rel, err := filepath.Rel(i.Dir, d.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dirs = append(dirs, rel)
+	}
+	return dirs
+}
+
+func TestRunOnly(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"vim", "zsh", "git", "work/ssh"} {
+		writeFile(t, filepath.Join(dir, name, "links.json"), "{}")
+	}
+	tests := []struct {
+		only []string
+		want []string
+	}{
+		{[]string{"vim"}, []string{"vim"}},
+		{[]string{"vim", "z*"}, []string{"vim", "zsh"}},
+		{[]string{"work/*"}, []string{"work/ssh"}},
+		{[]string{"nope"}, []string{}},
+	}
+	for _, test := range tests {
+		i := testInput(dir, t.TempDir())
+		i.Only = test.only
+		if got := listDirs(t, i); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("-only %q found %q, want %q", test.only, got, test.want)
+		}
+	}
+}
+
+func TestRunExclude(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"vim", "zsh", "git"} {
+		writeFile(t, filepath.Join(dir, name, "links.json"), "{}")
+	}
+	tests := []struct {
+		only, exclude []string
+		want          []string
+	}{
+		{nil, []string{"vim"}, []string{"git", "zsh"}},
+		{[]string{"vim", "zsh"}, nil, []string{"vim", "zsh"}},
+		// Exclude wins over only.
+		{[]string{"vim", "zsh"}, []string{"zsh"}, []string{"vim"}},
+		{[]string{"*"}, []string{"*"}, []string{}},
+	}
+	for _, test := range tests {
+		i := testInput(dir, t.TempDir())
+		i.Only, i.Exclude = test.only, test.exclude
+		if got := listDirs(t, i); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("-only %q -exclude %q found %q, want %q", test.only, test.exclude, got, test.want)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir, home := t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(dir, "zsh", "zshrc"), "")
+	writeFile(t, filepath.Join(dir, "zsh", "links.json"), `{"zshrc": "~/.zshrc"}`)
+	writeFile(t, filepath.Join(dir, "vim", "vimrc"), "")
+	writeFile(t, filepath.Join(dir, "vim", "links.json"), `{"vimrc": "$HOME/.vimrc"}`)
+	writeFile(t, filepath.Join(home, ".vimrc"), "mine")
+	summary, out := run(t, testInput(dir, home))
+	if want := (Summary{Created: 1, Conflicts: 1}); summary != want {
+		t.Errorf("Run returned %+v, want %+v", summary, want)
+	}
+	if got, want := summary.String(), "1 created, 0 skipped, 0 failed, 1 conflicts"; got != want {
+		t.Errorf("the summary is %q, want %q", got, want)
+	}
+	if !strings.Contains(out, filepath.Join(dir, "zsh", "zshrc")+" -> "+filepath.Join(home, ".zshrc")) {
+		t.Errorf("the report doesn't list the new link:\n%v", out)
+	}
+	if target, err := os.Readlink(filepath.Join(home, ".zshrc")); err != nil || target != filepath.Join(dir, "zsh", "zshrc") {
+		t.Errorf("%v links to %q, %v", filepath.Join(home, ".zshrc"), target, err)
+	}
+
+	// Running again skips the link already in place.
+	summary, _ = run(t, testInput(dir, home))
+	if want := (Summary{Skipped: 1, Conflicts: 1}); summary != want {
+		t.Errorf("the second Run returned %+v, want %+v", summary, want)
+	}
+}
+
+func TestRunSeveralRoots(t *testing.T) {
+	personal, work, home := t.TempDir(), t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(personal, "vimrc"), "")
+	writeFile(t, filepath.Join(personal, "links.json"), `{"vimrc": "~/.vimrc"}`)
+	writeFile(t, filepath.Join(work, "ssh", "config"), "")
+	writeFile(t, filepath.Join(work, "ssh", "links.json"), `{"config": "~/.ssh/config"}`)
+	i := testInput(personal+","+work, home)
+	i.MkdirAll = true
+	summary, _ := run(t, i)
+	if summary.Created != 2 {
+		t.Errorf("created %v links, want 2", summary.Created)
+	}
+	for dest, src := range map[string]string{".vimrc": filepath.Join(personal, "vimrc"), ".ssh/config": filepath.Join(work, "ssh", "config")} {
+		if target, err := os.Readlink(filepath.Join(home, dest)); err != nil || target != src {
+			t.Errorf("%v links to %q, %v, want %v", dest, target, err, src)
+		}
+	}
+}
+
+func TestRunRoot(t *testing.T) {
+	dir, home, root := t.TempDir(), t.TempDir(), t.TempDir()
+	t.Setenv("HOME", home)
+	writeFile(t, filepath.Join(dir, "vimrc"), "")
+	writeFile(t, filepath.Join(dir, "links.json"), `{"vimrc": "~/.vimrc"}`)
+	i := testInput(dir, "")
+	i.Root = root
+	i.MkdirAll = true
+	i.Prune = true
+	run(t, i)
+	if _, err := os.Lstat(filepath.Join(root, home, ".vimrc")); err != nil {
+		t.Errorf("the link isn't in the sandbox: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(root, home, StateFile)); err != nil {
+		t.Errorf("the state file isn't in the sandbox: %v", err)
+	}
+	if entries, err := os.ReadDir(home); err != nil || len(entries) > 0 {
+		t.Errorf("a sandboxed run changed the home directory: %v, %v", entries, err)
+	}
+}
+
+func TestRunStdin(t *testing.T) {
+	defer func(r io.Reader) { Stdin = r }(Stdin)
+	dir, home := t.TempDir(), t.TempDir()
+	t.Chdir(dir)
+	writeFile(t, filepath.Join(dir, "vimrc"), "")
+	// A links file in the working directory is ignored, only stdin is read.
+	writeFile(t, filepath.Join(dir, "links.json"), `{"vimrc": "~/.exrc"}`)
+	Stdin = strings.NewReader(`{"vimrc": "~/.vimrc"}`)
+	summary, _ := run(t, testInput("-", home))
+	if summary.Created != 1 {
+		t.Errorf("created %v links, want 1", summary.Created)
+	}
+	if target, err := os.Readlink(filepath.Join(home, ".vimrc")); err != nil || target != filepath.Join(dir, "vimrc") {
+		t.Errorf("%v links to %q, %v, want %v", filepath.Join(home, ".vimrc"), target, err, filepath.Join(dir, "vimrc"))
+	}
+	if _, err := os.Lstat(filepath.Join(home, ".exrc")); !os.IsNotExist(err) {
+		t.Error("the links file in the working directory was used")
+	}
+}
+
+func TestRunDuplicates(t *testing.T) {
+	dir, home := t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(dir, "vim", "vimrc"), "")
+	writeFile(t, filepath.Join(dir, "vim", "links.json"), `{"vimrc": "~/.vimrc"}`)
+	writeFile(t, filepath.Join(dir, "work", "links.json"), `{"../vim/vimrc": "~/.vimrc"}`)
+	summary, out := run(t, testInput(dir, home))
+	if want := (Summary{Created: 1, Skipped: 1}); summary != want {
+		t.Errorf("Run returned %+v, want %+v", summary, want)
+	}
+	src, dest := filepath.Join(dir, "vim", "vimrc"), filepath.Join(home, ".vimrc")
+	if target, err := os.Readlink(dest); err != nil || target != src {
+		t.Errorf("%v links to %q, %v, want %v", dest, target, err, src)
+	}
+	if want := fmt.Sprintf("Skipped:\n%v: %v -> %v", ErrDuplicate, src, dest); !strings.Contains(out, want) {
+		t.Errorf("the report doesn't list %q:\n%v", want, out)
+	}
+}
+
+func TestRunDuplicatesOrder(t *testing.T) {
+	dir := t.TempDir()
+	// All claim ~/.vimrc, the first DotDir found winning however the reads finish.
+	for _, name := range []string{"a", "b", "c", "d"} {
+		writeFile(t, filepath.Join(dir, name, "vimrc"), "")
+		writeFile(t, filepath.Join(dir, name, "links.json"), `{"vimrc": "~/.vimrc"}`)
+	}
+	for n := 0; n < 20; n++ {
+		home := t.TempDir()
+		i := testInput(dir, home)
+		i.Force, i.ParallelDirs = true, 4
+		var out bytes.Buffer
+		Run(i, &out)
+		if target, _ := os.Readlink(filepath.Join(home, ".vimrc")); target != filepath.Join(dir, "a", "vimrc") {
+			t.Fatalf("run %v linked ~/.vimrc to %q, want the source of the first DotDir", n, target)
+		}
+		for _, name := range []string{"b", "c", "d"} {
+			if want := fmt.Sprintf("%v: %v -> ", ErrDuplicate, filepath.Join(dir, name, "vimrc")); !strings.Contains(out.String(), want) {
+				t.Fatalf("run %v doesn't report %q:\n%v", n, want, out.String())
+			}
+		}
+	}
+}
+
+func TestRunSince(t *testing.T) {
+	dir, home := t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(dir, "vimrc"), "old")
+	writeFile(t, filepath.Join(dir, "zshrc"), "old")
+	writeFile(t, filepath.Join(dir, "links.json"), `{"vimrc": "~/.vimrc", "zshrc": "~/.zshrc"}`)
+	i := testInput(dir, home)
+	i.Since = true
+	i.Copy = true
+	i.Force = true
+	if summary, _ := run(t, i); summary.Copied != 2 {
+		t.Fatalf("the first run copied %v files, want 2", summary.Copied)
+	}
+
+	// Only the source with a new modification time is copied again.
+	info, err := os.Stat(filepath.Join(dir, "zshrc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, "vimrc"), "new")
+	writeFile(t, filepath.Join(dir, "zshrc"), "new")
+	if err := os.Chtimes(filepath.Join(dir, "vimrc"), time.Time{}, info.ModTime().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(dir, "zshrc"), time.Time{}, info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	summary, out := run(t, i)
+	if summary.Copied != 1 || summary.Skipped != 1 {
+		t.Errorf("the second run returned %+v, want 1 copied and 1 skipped", summary)
+	}
+	for dest, want := range map[string]string{".vimrc": "new", ".zshrc": "old"} {
+		if data, err := os.ReadFile(filepath.Join(home, dest)); err != nil || string(data) != want {
+			t.Errorf("%v has %q, %v, want %q", dest, data, err, want)
+		}
+	}
+	if want := fmt.Sprintf("%v: %v", ErrUnchanged, filepath.Join(dir, "zshrc")); !strings.Contains(out, want) {
+		t.Errorf("the report doesn't list %q:\n%v", want, out)
+	}
+}
+
+func TestRunSorted(t *testing.T) {
+	dir := t.TempDir()
+	// The sources are in the reverse order of their destinations.
+	writeFile(t, filepath.Join(dir, "a"), "")
+	writeFile(t, filepath.Join(dir, "b"), "")
+	writeFile(t, filepath.Join(dir, "c"), "")
+	writeFile(t, filepath.Join(dir, "links.json"), `{"a": "~/z", "b": "~/y", "c": "~/x"}`)
+	var outs []string
+	for n := 0; n < 5; n++ {
+		home := t.TempDir()
+		i := testInput(dir, home)
+		i.ParallelLinks = 3
+		_, out := run(t, i)
+		outs = append(outs, strings.ReplaceAll(out, home, "~"))
+	}
+	want := fmt.Sprintf("%v -> ~/x\n%v -> ~/y\n%v -> ~/z\n", filepath.Join(dir, "c"), filepath.Join(dir, "b"), filepath.Join(dir, "a"))
+	for _, out := range outs {
+		if out != want {
+			t.Errorf("the report is\n%v\nwant\n%v", out, want)
+		}
+	}
+}
+
+func TestRunMissingDir(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing")
+	_, err := Run(testInput(missing, t.TempDir()), &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "the dotfiles directory "+missing+" is missing") || !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Run over a missing directory returned %v", err)
+	}
+
+	file := filepath.Join(t.TempDir(), "file")
+	writeFile(t, file, "")
+	if _, err := Run(testInput(file, t.TempDir()), &bytes.Buffer{}); err == nil || !strings.Contains(err.Error(), "is not a directory") {
+		t.Errorf("Run over a file returned %v", err)
+	}
+
+	// -allow-missing skips it, linking the other directories.
+	dir, home := t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(dir, "vimrc"), "")
+	writeFile(t, filepath.Join(dir, "links.json"), `{"vimrc": "~/.vimrc"}`)
+	i := testInput(missing+","+dir, home)
+	i.AllowMissing = true
+	if summary, _ := run(t, i); summary.Created != 1 {
+		t.Errorf("Run with -allow-missing returned %+v, want 1 created", summary)
+	}
+}
+
+func TestRunDryPreview(t *testing.T) {
+	dir, home := t.TempDir(), t.TempDir()
+	for _, name := range []string{"new", "linked", "elsewhere", "file", "forced"} {
+		writeFile(t, filepath.Join(dir, name), "")
+	}
+	writeFile(t, filepath.Join(dir, "links.json"), `{
+	"new": "~/new",
+	"linked": "~/linked",
+	"elsewhere": {"dest": "~/elsewhere", "force": true},
+	"file": "~/file",
+	"forced": {"dest": "~/forced", "force": true}
+}`)
+	if err := os.Symlink(filepath.Join(dir, "linked"), filepath.Join(home, "linked")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "new"), filepath.Join(home, "elsewhere")); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(home, "file"), "mine")
+	writeFile(t, filepath.Join(home, "forced"), "mine")
+
+	i := testInput(dir, home)
+	i.Dry, i.JSON = true, true
+	_, out := run(t, i)
+	var results map[string][]struct{ Dest string }
+	if err := json.Unmarshal([]byte(out), &results); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string][]string{}
+	for key, r := range results {
+		for _, result := range r {
+			got[key] = append(got[key], filepath.Base(result.Dest))
+		}
+	}
+	want := map[string][]string{
+		"new":      {"new"},
+		"skip":     {"linked"},
+		"replace":  {"elsewhere", "forced"},
+		"conflict": {"file"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("the dry run grouped the links as %v, want %v", got, want)
+	}
+}
+
+func TestRunOtherFailures(t *testing.T) {
+	dir, home := t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(dir, "vimrc"), "")
+	writeFile(t, filepath.Join(dir, "links.json"), `{"vimrc": "~/.vimrc"}`)
+	writeFile(t, filepath.Join(home, ".vimrc"), "mine")
+	// The backup fails with a *PathError as the backup directory is a file.
+	backups := filepath.Join(t.TempDir(), "backups")
+	writeFile(t, backups, "")
+	i := testInput(dir, home)
+	i.Force, i.Backup, i.BackupDir = true, true, backups
+	summary, out := run(t, i)
+	if summary.Failed != 1 {
+		t.Errorf("Run returned %+v, want 1 failed", summary)
+	}
+	if want := "mkdir " + backups + ": not a directory: " + filepath.Join(dir, "vimrc"); !strings.Contains(out, want) {
+		t.Errorf("the report doesn't hold %q:\n%v", want, out)
+	}
+}
+
+// BenchmarkRunParallelLinks compares the numbers of link workers. The workers wait on the file system in parallel, so they pay off with several CPUs or a slow file system such as a network mount.
+func BenchmarkRunParallelLinks(b *testing.B) {
+	// One large DotDir, so only the link workers can run in parallel.
+	dir := b.TempDir()
+	links := map[string]string{}
+	for n := 0; n < 1000; n++ {
+		name := fmt.Sprintf("file%v", n)
+		writeFile(b, filepath.Join(dir, name), "")
+		links[name] = "~/" + name
+	}
+	data, err := json.Marshal(links)
+	if err != nil {
+		b.Fatal(err)
+	}
+	writeFile(b, filepath.Join(dir, "links.json"), string(data))
+	for _, workers := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("workers=%v", workers), func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				b.StopTimer()
+				i := testInput(dir, b.TempDir())
+				i.ParallelLinks = workers
+				b.StartTimer()
+				summary, err := Run(i, io.Discard)
+				if err != nil || summary.Created != len(links) {
+					b.Fatalf("Run returned %+v, %v", summary, err)
+				}
+			}
+		})
+	}
+}
+
+// corruptor is an Observer replacing the link of each successful result with a file, as a misbehaving file system might leave it.
+type corruptor struct{ t *testing.T }
+
+func (c corruptor) OnStart(l Link) {}
+
+func (c corruptor) OnResult(kind string, r LinkResult) {
+	if kind != "successes" || filepath.Base(r.Link.Dest) != ".zshrc" {
+		return
+	}
+	if err := os.Remove(r.Link.Dest); err != nil {
+		c.t.Error(err)
+	}
+	if err := os.WriteFile(r.Link.Dest, nil, 0644); err != nil {
+		c.t.Error(err)
+	}
+}
+
+func TestRunVerify(t *testing.T) {
+	dir, home := t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(dir, "vimrc"), "")
+	writeFile(t, filepath.Join(dir, "zshrc"), "")
+	writeFile(t, filepath.Join(dir, "links.json"), `{"vimrc": "~/.vimrc", "zshrc": "~/.zshrc"}`)
+	i := testInput(dir, home)
+	i.Verify = true
+	// The Observer is told about each link once it is applied, before the verification.
+	var out bytes.Buffer
+	summary, err := RunContext(context.Background(), i, &out, corruptor{t})
+	if err == nil {
+		t.Error("Run succeeded with a corrupted link")
+	}
+	if summary.Created != 2 || summary.Failed != 1 {
+		t.Errorf("Run returned %+v, want 2 created and 1 failed", summary)
+	}
+	if want := "Verification failures:\n" + filepath.Join(home, ".zshrc") + " is blocked instead of a link to " + filepath.Join(dir, "zshrc"); !strings.Contains(out.String(), want) {
+		t.Errorf("the report doesn't hold %q:\n%v", want, out.String())
+	}
+
+	// Nothing fails once the links are in place.
+	os.Remove(filepath.Join(home, ".zshrc"))
+	if _, err := Run(i, &bytes.Buffer{}); err != nil {
+		t.Errorf("verifying the links in place failed: %v", err)
+	}
+}
+
+func TestRunRequires(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake command is a shell script")
+	}
+	dir, home, bin := t.TempDir(), t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(bin, "tmux"), "#!/bin/sh\n")
+	if err := os.Chmod(filepath.Join(bin, "tmux"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin)
+	writeFile(t, filepath.Join(dir, "tmux.conf"), "")
+	writeFile(t, filepath.Join(dir, "screenrc"), "")
+	writeFile(t, filepath.Join(dir, "links.json"), `{
+		"tmux.conf": {"dest": "~/.tmux.conf", "requires": ["tmux"]},
+		"screenrc": {"dest": "~/.screenrc", "requires": ["tmux", "screen"]}
+	}`)
+	summary, out := run(t, testInput(dir, home))
+	if summary.Created != 1 || summary.Skipped != 1 {
+		t.Errorf("Run returned %+v, want 1 created and 1 skipped", summary)
+	}
+	if _, err := os.Lstat(filepath.Join(home, ".tmux.conf")); err != nil {
+		t.Errorf("the link requiring an installed command wasn't created: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(home, ".screenrc")); !os.IsNotExist(err) {
+		t.Errorf("the link requiring a missing command was created: %v", err)
+	}
+	if want := "requires screen, not found in $PATH: " + filepath.Join(dir, "screenrc"); !strings.Contains(out, want) {
+		t.Errorf("the report doesn't hold %q:\n%v", want, out)
+	}
+}
+
+func TestRunMergeDirs(t *testing.T) {
+	dir, home := t.TempDir(), t.TempDir()
+	src := filepath.Join(dir, "nvim")
+	for name, contents := range map[string]string{"init.vim": "set nu", "colors.vim": "colo x", "lua/plugins.lua": "return {}"} {
+		writeFile(t, filepath.Join(src, name), contents)
+	}
+	writeFile(t, filepath.Join(dir, "links.json"), `{"nvim": "~/.config/nvim"}`)
+	// The destination already holds a file of its own, one of the source's files linked and another copied.
+	dest := filepath.Join(home, ".config", "nvim")
+	writeFile(t, filepath.Join(dest, "local.vim"), "mine")
+	writeFile(t, filepath.Join(dest, "colors.vim"), "colo x")
+	if err := os.Symlink(filepath.Join(src, "init.vim"), filepath.Join(dest, "init.vim")); err != nil {
+		t.Fatal(err)
+	}
+
+	i := testInput(dir, home)
+	i.MergeDirs = true
+	summary, out := run(t, i)
+	if summary.Merged != 1 || summary.Skipped != 2 || summary.Conflicts != 0 {
+		t.Errorf("Run returned %+v, want 1 merged and 2 skipped\n%v", summary, out)
+	}
+	want := map[string]string{
+		dest:                                      "directory",
+		filepath.Join(dest, "local.vim"):          "file mine",
+		filepath.Join(dest, "colors.vim"):         "file colo x",
+		filepath.Join(dest, "init.vim"):           "link to " + filepath.Join(src, "init.vim"),
+		filepath.Join(dest, "lua"):                "directory",
+		filepath.Join(dest, "lua", "plugins.lua"): "link to " + filepath.Join(src, "lua", "plugins.lua"),
+	}
+	if got := snapshot(t, dest); !reflect.DeepEqual(got, want) {
+		t.Errorf("after merging %v holds %q, want %q", dest, got, want)
+	}
+
+	// Without -merge-dirs the directory conflicts.
+	summary, _ = run(t, testInput(dir, home))
+	if summary.Conflicts != 1 {
+		t.Errorf("Run without MergeDirs returned %+v, want 1 conflict", summary)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// statCache remembers the entries of the directories it has read, so the states of many links into the same directory take a single read of it rather than a stat each. It must only be used while nothing is being linked, as it is never invalidated.
+type statCache struct {
+	mu sync.Mutex
+	// dirs maps each directory read to the types of its entries, or to nil if it doesn't exist.
+	dirs map[string]map[string]os.FileMode
+}
+
+func newStatCache() *statCache {
+	return &statCache{dirs: map[string]map[string]os.FileMode{}}
+}
+
+// lstat returns the type bits of the file at path, as the Type of os.Lstat's result would, or an error wrapping os.ErrNotExist if there is none. Directories that can't be read are skipped in favour of os.Lstat. A nil cache always uses os.Lstat.
+func (c *statCache) lstat(path string) (os.FileMode, error) {
+	if c == nil {
+		info, err := os.Lstat(path)
+		if err != nil {
+			return 0, err
+		}
+		return info.Mode().Type(), nil
+	}
+	dir, name := filepath.Split(path)
+	c.mu.Lock()
+	entries, ok := c.dirs[dir]
+	if !ok {
+		entries, ok = readEntries(dir)
+		if ok {
+			c.dirs[dir] = entries
+		}
+	}
+	c.mu.Unlock()
+	if !ok {
+		return (*statCache)(nil).lstat(path)
+	}
+	mode, ok := entries[name]
+	if !ok {
+		return 0, &os.PathError{Op: "lstat", Path: path, Err: os.ErrNotExist}
+	}
+	return mode, nil
+}
+
+// readEntries returns the types of the entries of dir, or nil if it doesn't exist. It reports false if dir couldn't be read for another reason.
+func readEntries(dir string) (map[string]os.FileMode, bool) {
+	list, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, true
+	}
+	if err != nil {
+		return nil, false
+	}
+	entries := make(map[string]os.FileMode, len(list))
+	for _, entry := range list {
+		entries[entry.Name()] = entry.Type()
+	}
+	return entries, true
+}
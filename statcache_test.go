@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// statTree returns the links to n files in a directory, a third of them linked, a third blocked by a real file and the rest missing, along with a few into missing directories.
+func statTree(t testing.TB, n int) []Link {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "home"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	var links []Link
+	for k := 0; k < n; k++ {
+		l := Link{Src: filepath.Join(dir, "src", fmt.Sprint(k)), Dest: filepath.Join(dir, "home", fmt.Sprint(k))}
+		writeFile(t, l.Src, "")
+		switch k % 3 {
+		case 0:
+			if err := os.Symlink(l.Src, l.Dest); err != nil {
+				t.Fatal(err)
+			}
+		case 1:
+			writeFile(t, l.Dest, "mine")
+		}
+		links = append(links, l)
+	}
+	links = append(links,
+		Link{Src: filepath.Join(dir, "src", "0"), Dest: filepath.Join(dir, "missing", "x")},
+		Link{Src: filepath.Join(dir, "src", "0"), Dest: filepath.Join(dir, "home", "0", "x")},
+		Link{Src: filepath.Join(dir, "src", "0"), Dest: filepath.Join(dir, "src")},
+	)
+	return links
+}
+
+func TestStatCache(t *testing.T) {
+	links := statTree(t, 30)
+	cache := newStatCache()
+	for _, l := range links {
+		if cached, uncached := l.state(cache), l.State(); cached != uncached {
+			t.Errorf("the cached state of %v is %v, want %v", l.Dest, cached, uncached)
+		}
+		cached, cerr := cache.lstat(l.Dest)
+		info, err := os.Lstat(l.Dest)
+		if (cerr == nil) != (err == nil) || os.IsNotExist(cerr) != os.IsNotExist(err) {
+			t.Errorf("the cached lstat of %v failed with %v, want %v", l.Dest, cerr, err)
+			continue
+		}
+		if err == nil && cached != info.Mode().Type() {
+			t.Errorf("the cached lstat of %v is %v, want %v", l.Dest, cached, info.Mode().Type())
+		}
+	}
+}
+
+func BenchmarkStatCache(b *testing.B) {
+	links := statTree(b, 1000)
+	b.Run("uncached", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			for _, l := range links {
+				l.State()
+			}
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			// A status run starts with an empty cache.
+			cache := newStatCache()
+			for _, l := range links {
+				l.state(cache)
+			}
+		}
+	})
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// StateFile is the name of the file in the home directory recording the links created by bootstrap.
+const StateFile = ".bootstrap-state.json"
+
+// State records the links created by bootstrap so they can be pruned once they are removed from the links files. It doubles as a manifest of what was linked and when.
+type State struct {
+	// Links maps each destination to the source it was linked to.
+	Links map[string]string `json:"links"`
+	// Linked maps each destination to the time it was first linked.
+	Linked map[string]time.Time `json:"linked,omitempty"`
+	// Modtimes maps each destination to the modification time of its source when it was last linked or copied.
+	Modtimes map[string]time.Time `json:"modtimes,omitempty"`
+}
+
+// newState returns an empty State.
+func newState() *State {
+	return &State{Links: map[string]string{}, Linked: map[string]time.Time{}, Modtimes: map[string]time.Time{}}
+}
+
+// LoadState reads the State from the file at path. An empty State is returned if the file doesn't exist.
+func LoadState(path string) (*State, error) {
+	s := newState()
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	err = json.NewDecoder(f).Decode(s)
+	if err != nil {
+		return nil, err
+	}
+	if s.Links == nil {
+		s.Links = map[string]string{}
+	}
+	if s.Linked == nil {
+		s.Linked = map[string]time.Time{}
+	}
+	if s.Modtimes == nil {
+		s.Modtimes = map[string]time.Time{}
+	}
+	return s, nil
+}
+
+// link records that l is linked, keeping the time of an earlier link to the same source.
+func (s *State) link(l Link) {
+	if s.Links[l.Dest] != l.Src {
+		s.Linked[l.Dest] = time.Now()
+	}
+	s.Links[l.Dest] = l.Src
+}
+
+// touch records the modification time of the source of l.
+func (s *State) touch(l Link) {
+	info, err := os.Stat(l.Src)
+	if err == nil {
+		s.Modtimes[l.Dest] = info.ModTime()
+	}
+}
+
+// unchanged reports whether the source of l still has the modification time recorded by touch.
+func (s *State) unchanged(l Link) bool {
+	t, ok := s.Modtimes[l.Dest]
+	if !ok {
+		return false
+	}
+	info, err := os.Stat(l.Src)
+	return err == nil && info.ModTime().Equal(t)
+}
+
+// owns reports whether dest is a symlink recorded by link, so it belongs to bootstrap whatever it points at now.
+func (s *State) owns(dest string) bool {
+	if _, ok := s.Links[dest]; !ok {
+		return false
+	}
+	info, err := os.Lstat(dest)
+	return err == nil && info.Mode()&os.ModeSymlink != 0
+}
+
+// forget removes everything recorded about the destination.
+func (s *State) forget(dest string) {
+	delete(s.Links, dest)
+	delete(s.Linked, dest)
+	delete(s.Modtimes, dest)
+}
+
+// Save writes the State to the file at path. The file is replaced atomically, so it is never left partly written.
+func (s *State) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
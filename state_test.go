@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRunManifest(t *testing.T) {
+	dir, home := t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(dir, "vimrc"), "")
+	writeFile(t, filepath.Join(dir, "zshrc"), "")
+	writeFile(t, filepath.Join(dir, "links.json"), `{"vimrc": "~/.vimrc", "zshrc": "~/.zshrc"}`)
+	i := testInput(dir, home)
+	i.StateFile = filepath.Join(t.TempDir(), "manifest.json")
+	start := time.Now()
+	run(t, i)
+
+	s, err := LoadState(i.StateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		filepath.Join(home, ".vimrc"): filepath.Join(dir, "vimrc"),
+		filepath.Join(home, ".zshrc"): filepath.Join(dir, "zshrc"),
+	}
+	if !reflect.DeepEqual(s.Links, want) {
+		t.Errorf("the manifest holds %v, want %v", s.Links, want)
+	}
+	for dest := range want {
+		if linked := s.Linked[dest]; linked.Before(start) || linked.After(time.Now()) {
+			t.Errorf("%v was linked at %v, want the time of the run", dest, linked)
+		}
+	}
+
+	// Nothing is left behind by the atomic write.
+	entries, err := os.ReadDir(filepath.Dir(i.StateFile))
+	if err != nil || len(entries) != 1 {
+		t.Errorf("the manifest directory holds %v, %v, want only the manifest", entries, err)
+	}
+}
+
+func TestStateSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	s := newState()
+	s.link(Link{Src: "/dot/vimrc", Dest: "/home/u/.vimrc"})
+	if err := s.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.Links, s.Links) || !loaded.Linked["/home/u/.vimrc"].Equal(s.Linked["/home/u/.vimrc"]) {
+		t.Errorf("LoadState returned %+v, want %+v", loaded, s)
+	}
+
+	// A missing file is an empty State.
+	s, err = LoadState(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil || len(s.Links) != 0 {
+		t.Errorf("LoadState of a missing file = %+v, %v, want an empty State", s, err)
+	}
+}
+
+func TestRunSync(t *testing.T) {
+	dir, home, other := t.TempDir(), t.TempDir(), t.TempDir()
+	for _, name := range []string{"vimrc", "zshrc", "gitconfig", "tmux.conf"} {
+		writeFile(t, filepath.Join(dir, name), "")
+	}
+	writeFile(t, filepath.Join(dir, "links.json"), `{"vimrc": "~/.vimrc", "zshrc": "~/.zshrc", "gitconfig": "~/.gitconfig"}`)
+	i := testInput(dir, home)
+	i.StateFile = filepath.Join(t.TempDir(), "state.json")
+	run(t, i)
+
+	// Drift from the links files: a link removed, one pointing elsewhere, one dropped from the links files and one added to them.
+	if err := os.Remove(filepath.Join(home, ".vimrc")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(home, ".zshrc")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(other, "zshrc"), filepath.Join(home, ".zshrc")); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, "links.json"), `{"vimrc": "~/.vimrc", "zshrc": "~/.zshrc", "tmux.conf": "~/.tmux.conf"}`)
+	// Links bootstrap didn't make are left alone.
+	if err := os.Symlink(filepath.Join(other, "bashrc"), filepath.Join(home, ".bashrc")); err != nil {
+		t.Fatal(err)
+	}
+
+	i.Sync = true
+	run(t, i)
+	want := map[string]string{
+		home:                              "directory",
+		filepath.Join(home, ".vimrc"):     "link to " + filepath.Join(dir, "vimrc"),
+		filepath.Join(home, ".zshrc"):     "link to " + filepath.Join(dir, "zshrc"),
+		filepath.Join(home, ".tmux.conf"): "link to " + filepath.Join(dir, "tmux.conf"),
+		filepath.Join(home, ".bashrc"):    "link to " + filepath.Join(other, "bashrc"),
+	}
+	if got := snapshot(t, home); !reflect.DeepEqual(got, want) {
+		t.Errorf("after syncing %v holds %q, want %q", home, got, want)
+	}
+	s, err := LoadState(i.StateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantLinks := map[string]string{
+		filepath.Join(home, ".vimrc"):     filepath.Join(dir, "vimrc"),
+		filepath.Join(home, ".zshrc"):     filepath.Join(dir, "zshrc"),
+		filepath.Join(home, ".tmux.conf"): filepath.Join(dir, "tmux.conf"),
+	}
+	if !reflect.DeepEqual(s.Links, wantLinks) {
+		t.Errorf("the state holds %v, want %v", s.Links, wantLinks)
+	}
+
+	// Syncing again changes nothing.
+	summary, _ := run(t, i)
+	if summary.Created != 0 || summary.Pruned != 0 || summary.Skipped != 3 {
+		t.Errorf("syncing again returned %+v, want only the 3 links skipped", summary)
+	}
+}
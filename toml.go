@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// decodeTOML parses a TOML document of `"source" = "destination"` pairs. Only the subset of TOML needed by a links file is supported: comments, blank lines, bare or quoted keys and single line basic or literal strings.
+func decodeTOML(r io.Reader) (map[string]string, error) {
+	m := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			return nil, fmt.Errorf("line %v: tables are not supported", n)
+		}
+		key, rest, err := tomlKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", n, err)
+		}
+		rest = strings.TrimSpace(rest)
+		if !strings.HasPrefix(rest, "=") {
+			return nil, fmt.Errorf("line %v: expected key = value", n)
+		}
+		value, rest, err := tomlString(strings.TrimSpace(rest[1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", n, err)
+		}
+		if rest = strings.TrimSpace(rest); rest != "" && !strings.HasPrefix(rest, "#") {
+			return nil, fmt.Errorf("line %v: unexpected %q", n, rest)
+		}
+		if _, ok := m[key]; ok {
+			return nil, fmt.Errorf("line %v: duplicate key %q", n, key)
+		}
+		m[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// tomlKey reads a bare or quoted key from the start of s and returns it along with the remaining text.
+func tomlKey(s string) (key, rest string, err error) {
+	if strings.HasPrefix(s, `"`) || strings.HasPrefix(s, "'") {
+		return tomlString(s)
+	}
+	end := strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' || r == '-')
+	})
+	if end < 0 {
+		end = len(s)
+	}
+	if end == 0 {
+		return "", "", fmt.Errorf("invalid key %v", s)
+	}
+	if s[end:] != "" && s[end] == '.' {
+		return "", "", fmt.Errorf("dotted keys are not supported, quote %v", s)
+	}
+	return s[:end], s[end:], nil
+}
+
+// tomlString reads a basic "..." or literal '...' string from the start of s and returns it along with the remaining text.
+func tomlString(s string) (value, rest string, err error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		for n := 1; n < len(s); n++ {
+			if s[n] == '\\' {
+				n++
+				continue
+			}
+			if s[n] == '"' {
+				value, err = strconv.Unquote(s[:n+1])
+				return value, s[n+1:], err
+			}
+		}
+	case strings.HasPrefix(s, "'"):
+		if n := strings.IndexByte(s[1:], '\''); n >= 0 {
+			return s[1 : n+1], s[n+2:], nil
+		}
+	default:
+		return "", "", fmt.Errorf("expected a string, got %v", s)
+	}
+	return "", "", fmt.Errorf("unterminated string %v", s)
+}
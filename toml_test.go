@@ -0,0 +1,43 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDecodeTOMLErrors(t *testing.T) {
+	tests := []struct {
+		data, err string
+	}{
+		{`[links]`, "line 1: tables are not supported"},
+		{`vimrc "/home/test/.vimrc"`, "line 1: expected key = value"},
+		{`vimrc = /home/test/.vimrc`, "line 1: "},
+		{`vimrc = "/home/test/.vimrc`, "line 1: "},
+		{"vimrc = \"a\"\nvimrc = \"b\"", `line 2: duplicate key "vimrc"`},
+		{`vimrc = "a" "b"`, `line 1: unexpected "\"b\""`},
+	}
+	for _, test := range tests {
+		_, err := decodeTOML(strings.NewReader(test.data))
+		if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+			t.Errorf("decodeTOML(%q) returned %v, want %q", test.data, err, test.err)
+		}
+	}
+
+	// Links names the malformed file.
+	dir := t.TempDir()
+	linkFile := filepath.Join(dir, "links.toml")
+	writeFile(t, linkFile, "vimrc = \"a\"\n[links]\n")
+	_, err := DotDir{Path: dir, LinkFiles: []string{linkFile}}.Links()
+	if want := "parsing " + linkFile + ": line 2: tables are not supported"; err == nil || err.Error() != want {
+		t.Errorf("Links returned %v, want %q", err, want)
+	}
+}
+
+func TestWalkTOML(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "vim", "links.toml"), `vimrc = "/home/test/.vimrc"`)
+	if got := walkDirs(t, NewBootstrap(), dir); len(got) != 1 || got[0] != "vim" {
+		t.Errorf("Walk found %q, want the directory of links.toml", got)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// decodeYAML parses a flat YAML mapping of "source: destination" pairs. Only the subset of YAML needed by a links file is supported: comments, blank lines, document markers and single line plain or quoted scalars.
+func decodeYAML(r io.Reader) (map[string]string, error) {
+	m := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || trimmed == "---" || trimmed == "..." {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			return nil, fmt.Errorf("line %v: nested values are not supported", n)
+		}
+		key, rest, err := yamlScalar(trimmed, true)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", n, err)
+		}
+		rest = strings.TrimSpace(rest)
+		if !strings.HasPrefix(rest, ":") {
+			return nil, fmt.Errorf("line %v: expected \"key: value\"", n)
+		}
+		value, rest, err := yamlScalar(strings.TrimSpace(rest[1:]), false)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", n, err)
+		}
+		if rest = strings.TrimSpace(rest); rest != "" && !strings.HasPrefix(rest, "#") {
+			return nil, fmt.Errorf("line %v: unexpected %q", n, rest)
+		}
+		if value == "" {
+			return nil, fmt.Errorf("line %v: missing value for %q", n, key)
+		}
+		m[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// yamlScalar reads a plain, single quoted or double quoted scalar from the start of s and returns it along with the remaining text. Plain keys end at the first ": " and plain values end at the first " #".
+func yamlScalar(s string, key bool) (scalar, rest string, err error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		for n := 1; n < len(s); n++ {
+			if s[n] == '\\' {
+				n++
+				continue
+			}
+			if s[n] == '"' {
+				scalar, err = strconv.Unquote(s[:n+1])
+				return scalar, s[n+1:], err
+			}
+		}
+		return "", "", fmt.Errorf("unterminated string %v", s)
+	case strings.HasPrefix(s, "'"):
+		for n := 1; n < len(s); n++ {
+			if s[n] != '\'' {
+				continue
+			}
+			if n+1 < len(s) && s[n+1] == '\'' {
+				n++
+				continue
+			}
+			return strings.Replace(s[1:n], "''", "'", -1), s[n+1:], nil
+		}
+		return "", "", fmt.Errorf("unterminated string %v", s)
+	}
+	end := len(s)
+	if key {
+		if n := strings.Index(s, ": "); n >= 0 {
+			end = n
+		} else if strings.HasSuffix(s, ":") {
+			end = len(s) - 1
+		}
+	} else if n := strings.Index(s, " #"); n >= 0 {
+		end = n
+	}
+	return strings.TrimSpace(s[:end]), s[end:], nil
+}